@@ -0,0 +1,376 @@
+package article
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/segmentio/ksuid"
+)
+
+var (
+	// ErrRateLimited 用户或IP在时间窗口内的投票次数超过限制
+	ErrRateLimited = errors.New("vote rate limited")
+	// ErrAccountTooNew 账号注册时间不足，不能投票
+	ErrAccountTooNew = errors.New("account too new to vote")
+	// ErrUserExists 用户已经注册
+	ErrUserExists = errors.New("user already exists")
+)
+
+// VoteLimits 投票的反作弊配置，值为0表示不限制
+type VoteLimits struct {
+	// Window 滑动窗口的长度
+	Window time.Duration
+	// PerUser 每个用户在窗口内最多投票的次数
+	PerUser int64
+	// PerIP 每个IP在窗口内最多投票的次数
+	PerIP int64
+	// MinAccountAge 账号注册后多久才能投票，未注册的账号视为新账号
+	MinAccountAge time.Duration
+}
+
+// VOTELIMITS 当前站点使用的投票限制
+var VOTELIMITS = VoteLimits{Window: time.Hour}
+
+// rateLimitScript 滑动窗口限流，所有窗口都未超限时才记录本次请求
+// KEYS: 每个限流维度的有序集合
+// ARGV: now(毫秒), window(毫秒), member, 每个KEYS对应的限制次数...
+// 返回值: 0 超过限制，1 允许
+const rateLimitScript = `
+local now, window = tonumber(ARGV[1]), tonumber(ARGV[2])
+for i, key in ipairs(KEYS) do
+    redis.call("zremrangebyscore", key, "-inf", now - window)
+    if redis.call("zcard", key) >= tonumber(ARGV[i + 3]) then
+        return 0
+    end
+end
+for _, key in ipairs(KEYS) do
+    redis.call("zadd", key, now, ARGV[3])
+    redis.call("pexpire", key, window)
+end
+return 1
+`
+
+// RegisterUser 记录用户的注册时间，用于判断账号是否满足最小注册时长。
+// 重复注册不会覆盖原来的注册时间，返回ErrUserExists。
+func RegisterUser(ctx context.Context, user string, created time.Time) error {
+	pipe := redis.Pipeline()
+	added := pipe.ZAddNX(ctx, "users:", redis.Z{Score: float64(created.Unix()), Member: user})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if added.Val() == 0 {
+		return fmt.Errorf("%w: %s", ErrUserExists, user)
+	}
+
+	return nil
+}
+
+// GuardedVote 在投票前检查限流、账号注册时长和刷票标记。
+// 被标记为刷票团伙的用户对相应发布者的文章投赞成票时，只记录在flagged-votes:<id>中，不计入评分；
+// 撤销投票和投反对票照常处理，同时清除之前被静默记录的赞成票。
+func GuardedVote(ctx context.Context, article, user, ip string, direction VoteDirection) error {
+	articleId, ok := strings.CutPrefix(article, "article:")
+	if !ok || articleId == "" {
+		return fmt.Errorf("%w: %s", ErrInvalidArticle, article)
+	}
+
+	if err := checkAccountAge(ctx, user); err != nil {
+		return err
+	}
+	if err := checkRateLimit(ctx, user, ip); err != nil {
+		return err
+	}
+
+	poster, err := redis.HGet(ctx, article, "poster")
+	if errors.Is(err, goredis.Nil) || (err == nil && poster == "") {
+		return fmt.Errorf("%w: %s", ErrArticleNotFound, article)
+	}
+	if err != nil {
+		return err
+	}
+
+	pipe := redis.Pipeline()
+	flagged := pipe.SIsMember(ctx, "flagged:"+poster, user)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if flagged.Val() {
+		if direction == VoteUp {
+			// 静默接受被标记的投票，避免刷票者察觉
+			_, err := redis.SAdd(ctx, "flagged-votes:"+articleId, user)
+			return err
+		}
+		if _, err := redis.SRem(ctx, "flagged-votes:"+articleId, user); err != nil {
+			return err
+		}
+	}
+
+	result, err := voteArticle(ctx, article, user, direction)
+	if err != nil {
+		return err
+	}
+
+	// 只有真正新增的赞成票才需要检测，记录发布者以便DetectVotingRings检查他的文章
+	if result.votes > 0 && user != poster {
+		if _, err := redis.SAdd(ctx, "poster-votes:", poster); err != nil {
+			logs.Warnw("failed to record poster votes", "poster", poster, "user", user, "error", err)
+		}
+	}
+
+	return nil
+}
+
+func checkAccountAge(ctx context.Context, user string) error {
+	if VOTELIMITS.MinAccountAge <= 0 {
+		return nil
+	}
+
+	// 未注册的用户同样视为新账号
+	created, err := redis.ZScore(ctx, "users:", user)
+	if errors.Is(err, goredis.Nil) {
+		return fmt.Errorf("%w: %s", ErrAccountTooNew, user)
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(time.Unix(int64(created), 0)) < VOTELIMITS.MinAccountAge {
+		return fmt.Errorf("%w: %s", ErrAccountTooNew, user)
+	}
+
+	return nil
+}
+
+func checkRateLimit(ctx context.Context, user, ip string) error {
+	keys := make([]string, 0, 2)
+	limits := make([]interface{}, 0, 2)
+	if VOTELIMITS.PerUser > 0 {
+		keys = append(keys, "ratelimit:user:"+user)
+		limits = append(limits, VOTELIMITS.PerUser)
+	}
+	if VOTELIMITS.PerIP > 0 && ip != "" {
+		keys = append(keys, "ratelimit:ip:"+ip)
+		limits = append(limits, VOTELIMITS.PerIP)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	args := append([]interface{}{time.Now().UnixMilli(), VOTELIMITS.Window.Milliseconds(), ksuid.New().String()}, limits...)
+	res, err := evalScript(ctx, rateLimitScript, keys, args...)
+	if err != nil {
+		return err
+	}
+	if res.(int64) == 0 {
+		return fmt.Errorf("%w: user %s ip %s", ErrRateLimited, user, ip)
+	}
+
+	return nil
+}
+
+// DetectVotingRings 检测刷票团伙：两个用户在window时间内先后为同一个发布者的同一篇文章投赞成票，
+// 记为一次共同投票，共同投票不少于minCoVotes次的用户之间存在关联，关联在一起的用户不少于minRing个时，
+// 将这些用户标记为该发布者的刷票者，并撤销他们对该发布者仍在投票期内的文章的投票。
+// 只检查投票期内的文章，长期支持同一个发布者但投票时间分散的读者不会被标记。
+// 返回每个发布者新标记的用户。
+func DetectVotingRings(ctx context.Context, minCoVotes int64, minRing int, window time.Duration) (map[string][]string, error) {
+	pipe := redis.Pipeline()
+	postersCmd := pipe.SMembers(ctx, "poster-votes:")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	rings := make(map[string][]string)
+	for _, poster := range postersCmd.Val() {
+		coVotes, active, err := countCoVotes(ctx, poster, window)
+		if err != nil {
+			return rings, err
+		}
+		// 发布者没有投票期内的文章，之后有新的赞成票时会重新记录
+		if !active {
+			if _, err := redis.SRem(ctx, "poster-votes:", poster); err != nil {
+				return rings, err
+			}
+			continue
+		}
+
+		for _, ring := range findRings(coVotes, minCoVotes, minRing) {
+			added, err := redis.SAdd(ctx, "flagged:"+poster, toInterfaces(ring)...)
+			if err != nil {
+				return rings, err
+			}
+			if added == 0 {
+				continue
+			}
+
+			logs.Infow("voting ring detected", "poster", poster, "voters", ring)
+			rings[poster] = append(rings[poster], ring...)
+			if err := excludeFlaggedVotes(ctx, poster, ring); err != nil {
+				return rings, err
+			}
+		}
+	}
+
+	return rings, nil
+}
+
+// votePair 两个投票者，a < b
+type votePair struct {
+	a, b string
+}
+
+// countCoVotes 统计发布者投票期内的文章中，每两个投票者在window时间内先后投赞成票的次数，
+// 发布者自己的投票不计入。active表示发布者是否还有投票期内的文章。
+func countCoVotes(ctx context.Context, poster string, window time.Duration) (map[votePair]int64, bool, error) {
+	now := time.Now().Unix()
+	articles, err := rangeByScore(ctx, "posted:"+poster, strconv.FormatInt(now-OneWeekInSeconds, 10), "+inf", 0, 0)
+	if err != nil {
+		return nil, false, err
+	}
+
+	coVotes := make(map[votePair]int64)
+	for _, entry := range articles {
+		article := entry.Member.(string)
+		articleId := strings.TrimPrefix(article, "article:")
+
+		pipe := redis.Pipeline()
+		votersCmd := pipe.SMembers(ctx, "voted:"+articleId)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, true, err
+		}
+		voters := make([]string, 0, len(votersCmd.Val()))
+		for _, voter := range votersCmd.Val() {
+			if voter != poster {
+				voters = append(voters, voter)
+			}
+		}
+		if len(voters) < 2 {
+			continue
+		}
+
+		// 投票时间记录在用户的投票索引中
+		pipe = redis.Pipeline()
+		timeCmds := make([]*goredis.FloatCmd, 0, len(voters))
+		for _, voter := range voters {
+			timeCmds = append(timeCmds, pipe.ZScore(ctx, "user-voted:"+voter, article))
+		}
+		// 没有投票索引的投票者ZSCORE返回错误，按没有投票时间处理
+		_, _ = pipe.Exec(ctx)
+
+		type vote struct {
+			voter string
+			time  float64
+		}
+		votes := make([]vote, 0, len(voters))
+		for i, voter := range voters {
+			if t, err := timeCmds[i].Result(); err == nil {
+				votes = append(votes, vote{voter: voter, time: t})
+			}
+		}
+		sort.Slice(votes, func(i, j int) bool { return votes[i].time < votes[j].time })
+
+		for i := range votes {
+			for j := i + 1; j < len(votes) && votes[j].time-votes[i].time <= window.Seconds(); j++ {
+				pair := votePair{a: votes[i].voter, b: votes[j].voter}
+				if pair.a > pair.b {
+					pair.a, pair.b = pair.b, pair.a
+				}
+				coVotes[pair]++
+			}
+		}
+	}
+
+	return coVotes, len(articles) > 0, nil
+}
+
+// findRings 将共同投票不少于minCoVotes次的投票者连在一起，返回人数不少于minRing的连通分量
+func findRings(coVotes map[votePair]int64, minCoVotes int64, minRing int) [][]string {
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(user string) string {
+		if parent[user] != user {
+			parent[user] = find(parent[user])
+		}
+		return parent[user]
+	}
+
+	for pair, count := range coVotes {
+		if count < minCoVotes {
+			continue
+		}
+		for _, user := range []string{pair.a, pair.b} {
+			if _, ok := parent[user]; !ok {
+				parent[user] = user
+			}
+		}
+		parent[find(pair.a)] = find(pair.b)
+	}
+
+	groups := make(map[string][]string)
+	for user := range parent {
+		root := find(user)
+		groups[root] = append(groups[root], user)
+	}
+
+	rings := make([][]string, 0)
+	for _, group := range groups {
+		if len(group) >= minRing {
+			sort.Strings(group)
+			rings = append(rings, group)
+		}
+	}
+	sort.Slice(rings, func(i, j int) bool { return rings[i][0] < rings[j][0] })
+	return rings
+}
+
+// excludeFlaggedVotes 撤销被标记用户对发布者投票期内文章的赞成票，并转存到flagged-votes:<id>
+func excludeFlaggedVotes(ctx context.Context, poster string, voters []string) error {
+	cutoff := time.Now().Unix() - OneWeekInSeconds
+	articles, err := rangeByTime(ctx, "posted:"+poster, time.Now().Unix(), 0, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range articles {
+		if int64(entry.Score) < cutoff {
+			continue
+		}
+
+		article := entry.Member.(string)
+		articleId := strings.TrimPrefix(article, "article:")
+		pipe := redis.Pipeline()
+		cmd := pipe.SMIsMember(ctx, "voted:"+articleId, toInterfaces(voters)...)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		for i, voted := range cmd.Val() {
+			if !voted {
+				continue
+			}
+			if err := ArticleUnvote(ctx, article, voters[i]); err != nil && !errors.Is(err, ErrVotingClosed) {
+				return err
+			}
+			if _, err := redis.SAdd(ctx, "flagged-votes:"+articleId, voters[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func toInterfaces(values []string) []interface{} {
+	res := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		res = append(res, value)
+	}
+	return res
+}
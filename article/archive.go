@@ -0,0 +1,282 @@
+package article
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+)
+
+// ArchiveOptions 归档选项
+type ArchiveOptions struct {
+	// Batch 每批处理的文章数量，默认100
+	Batch int64
+	// ColdAfter 发布超过多久的文章移动到cold:命名空间，0表示不移动，不能小于投票期限
+	ColdAfter time.Duration
+	// Export 移动到cold:命名空间的文章以JSON行的形式写入Export，为空表示不导出
+	Export io.Writer
+}
+
+// ColdArticle 导出文件中的一行
+type ColdArticle struct {
+	*Article
+	Groups []string `json:"groups,omitempty"`
+}
+
+// freezeScript 冻结投票期已结束的文章：记录最终评分，删除投票名单、投票权重和被静默记录的刷票
+// KEYS: article:<id>, voted:<id>, downvoted:<id>, archived:, score:, vote-weights:<id>, flagged-votes:<id>
+// ARGV: 文章发布时间
+// 返回值: 0 文章不存在，1 冻结成功
+const freezeScript = `
+if redis.call("exists", KEYS[1]) == 0 then
+    return 0
+end
+local score = redis.call("zscore", KEYS[5], KEYS[1]) or 0
+redis.call("hset", KEYS[1], "archived", 1, "final_score", score)
+redis.call("del", KEYS[2], KEYS[3], KEYS[6], KEYS[7])
+redis.call("zadd", KEYS[4], ARGV[1], KEYS[1])
+return 1
+`
+
+// lowerWatermarkScript 只在watermark大于ARGV[1]时调低
+// KEYS: archive:
+// ARGV: 发布时间
+const lowerWatermarkScript = `
+local watermark = redis.call("hget", KEYS[1], "watermark")
+if watermark and tonumber(watermark) > tonumber(ARGV[1]) then
+    redis.call("hset", KEYS[1], "watermark", ARGV[1])
+end
+return 0
+`
+
+// RunArchiver 每隔interval执行一次归档，直到ctx被取消
+func RunArchiver(ctx context.Context, interval time.Duration, opts ArchiveOptions) {
+	for {
+		frozen, moved, err := ArchiveArticles(ctx, opts)
+		if err != nil {
+			logs.Warnw("failed to archive articles", "error", err)
+		} else if frozen > 0 || moved > 0 {
+			logs.Infow("archived articles", "frozen", frozen, "moved", moved)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ArchiveArticles 冻结投票期已结束的文章，并按需将更旧的文章移动到cold:命名空间，
+// 返回冻结和移动的文章数量
+func ArchiveArticles(ctx context.Context, opts ArchiveOptions) (int, int, error) {
+	if opts.Batch <= 0 {
+		opts.Batch = 100
+	}
+
+	now := time.Now().Unix()
+	frozen, err := freezeArticles(ctx, now-OneWeekInSeconds, opts.Batch)
+	if err != nil || opts.ColdAfter <= 0 {
+		return frozen, 0, err
+	}
+
+	// 投票期内的文章不能移动到冷存储
+	coldCutoff := min(now-int64(opts.ColdAfter/time.Second), now-OneWeekInSeconds)
+	moved, err := moveColdArticles(ctx, coldCutoff, opts)
+	return frozen, moved, err
+}
+
+// rangeByTime 按发布时间从旧到新获取发布时间不晚于cutoff的文章
+func rangeByTime(ctx context.Context, key string, cutoff, offset, count int64) ([]redis.Z, error) {
+	return rangeByScore(ctx, key, "-inf", strconv.FormatInt(cutoff, 10), offset, count)
+}
+
+// rangeByScore 按评分从低到高获取评分在[min, max]之间的成员
+func rangeByScore(ctx context.Context, key, min, max string, offset, count int64) ([]redis.Z, error) {
+	pipe := redis.Pipeline()
+	cmd := pipe.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+		Min:    min,
+		Max:    max,
+		Offset: offset,
+		Count:  count,
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Val(), nil
+}
+
+// freezeArticles 冻结发布时间不晚于cutoff的文章，返回冻结的文章数量。
+// 冻结进度保存在散列archive:的watermark字段中，每次只处理发布时间不早于watermark的文章，
+// 导入发布时间更早的文章时会调低watermark。
+func freezeArticles(ctx context.Context, cutoff, batch int64) (int, error) {
+	state, err := redis.HGetAll(ctx, "archive:")
+	if err != nil {
+		return 0, err
+	}
+	// 同一时刻发布的文章可能只处理了一部分，从watermark本身开始，已冻结的文章会被跳过
+	watermark := "-inf"
+	if value, ok := state["watermark"]; ok {
+		watermark = value
+	}
+
+	frozen := 0
+	for offset := int64(0); ; offset += batch {
+		entries, err := rangeByScore(ctx, "time:", watermark, strconv.FormatInt(cutoff, 10), offset, batch)
+		if err != nil {
+			return frozen, err
+		}
+		if len(entries) == 0 {
+			return frozen, nil
+		}
+
+		keys := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			keys = append(keys, entry.Member.(string))
+		}
+
+		// 跳过已经冻结过的文章
+		archived, err := zmScore(ctx, "archived:", keys)
+		if err != nil {
+			return frozen, err
+		}
+
+		for i, entry := range entries {
+			if archived[i] > 0 {
+				continue
+			}
+
+			article := keys[i]
+			articleId := strings.TrimPrefix(article, "article:")
+			scriptKeys := []string{article, "voted:" + articleId, "downvoted:" + articleId, "archived:", "score:",
+				"vote-weights:" + articleId, "flagged-votes:" + articleId}
+			if _, err := evalScript(ctx, freezeScript, scriptKeys, int64(entry.Score)); err != nil {
+				return frozen, err
+			}
+			frozen++
+		}
+
+		// 整批处理完之后再推进watermark
+		last := int64(entries[len(entries)-1].Score)
+		if _, err := redis.HSet(ctx, "archive:", "watermark", last); err != nil {
+			return frozen, err
+		}
+	}
+}
+
+// lowerArchiveWatermark 导入发布时间早于watermark的文章后调低watermark，保证它们也会被冻结
+func lowerArchiveWatermark(ctx context.Context, posted int64) error {
+	_, err := evalScript(ctx, lowerWatermarkScript, []string{"archive:"}, posted)
+	return err
+}
+
+func moveColdArticles(ctx context.Context, cutoff int64, opts ArchiveOptions) (int, error) {
+	moved := 0
+	var encoder *json.Encoder
+	if opts.Export != nil {
+		encoder = json.NewEncoder(opts.Export)
+	}
+
+	// 移动后的文章会从time:中删除，所以每次都从头开始获取
+	for {
+		entries, err := rangeByTime(ctx, "archived:", cutoff, 0, opts.Batch)
+		if err != nil {
+			return moved, err
+		}
+		if len(entries) == 0 {
+			return moved, nil
+		}
+
+		for _, entry := range entries {
+			article := entry.Member.(string)
+			if err := moveColdArticle(ctx, article, int64(entry.Score), encoder); err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+}
+
+// moveColdArticle 将文章散列和它的群组名单重命名到cold:命名空间，并从评分、时间、热门列表、群组和全文索引中删除
+func moveColdArticle(ctx context.Context, article string, posted int64, encoder *json.Encoder) error {
+	articleId := strings.TrimPrefix(article, "article:")
+	groupsKey := "groups:" + articleId
+
+	var groups []string
+	var record *ColdArticle
+	err := watchWithRetry(ctx, func(tx *redis.Tx) error {
+		record = nil
+		data, err := tx.HGetAll(ctx, article).Result()
+		if err != nil {
+			return err
+		}
+
+		// 文章已被删除，只需要清理归档记录
+		if len(data) == 0 {
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.ZRem(ctx, "archived:", article)
+				return nil
+			})
+			return err
+		}
+
+		decoded, err := DecodeArticle(article, data)
+		if err != nil {
+			return err
+		}
+		if decoded.Score, err = strconv.ParseFloat(data["final_score"], 64); err != nil {
+			return fmt.Errorf("article %s: invalid final_score: %w", article, err)
+		}
+		if groups, err = tx.SMembers(ctx, groupsKey).Result(); err != nil {
+			return err
+		}
+
+		record = &ColdArticle{Article: decoded, Groups: groups}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Rename(ctx, article, "cold:"+article)
+			pipe.ZAdd(ctx, "cold:time:", redis.Z{Score: float64(posted), Member: article})
+			pipe.ZRem(ctx, "score:", article)
+			pipe.ZRem(ctx, "time:", article)
+			pipe.ZRem(ctx, "archived:", article)
+			removeFromTrending(ctx, pipe, article, time.Now().Unix())
+			if len(groups) > 0 {
+				pipe.Rename(ctx, groupsKey, "cold:"+groupsKey)
+			}
+			for _, group := range groups {
+				pipe.SRem(ctx, "group:"+group, article)
+			}
+			indexTitle(ctx, pipe, article, decoded.Title, "")
+			return nil
+		})
+		return err
+	}, article, groupsKey)
+	if err != nil {
+		return err
+	}
+
+	// 事务提交之后再写导出文件，事务失败重试时不会写入没有移动的文章。
+	// 写入失败时文章仍然完整地保存在cold:命名空间中。
+	if encoder != nil && record != nil {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("article %s moved to cold storage but not exported: %w", article, err)
+		}
+	}
+
+	return invalidateGroups(ctx, groups...)
+}
+
+// zmScore 使用ZMSCORE批量获取成员的评分，不存在的成员评分为0
+func zmScore(ctx context.Context, key string, members []string) ([]float64, error) {
+	pipe := redis.Pipeline()
+	cmd := pipe.ZMScore(ctx, key, members...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Val(), nil
+}
@@ -2,6 +2,8 @@ package article
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -16,42 +18,266 @@ const (
 	ArticlesPrePage  = 25
 )
 
+var (
+	// ErrInvalidArticle 文章键的格式不是article:<id>
+	ErrInvalidArticle = errors.New("invalid article format")
+	// ErrVotingClosed 文章发布已超过一周，不能再投票
+	ErrVotingClosed = errors.New("voting closed")
+)
+
+// VoteDirection 表示用户对文章的投票方向
+type VoteDirection int
+
+const (
+	VoteNone VoteDirection = 0
+	VoteUp   VoteDirection = 1
+	VoteDown VoteDirection = -1
+)
+
+// ArticleVote 为文章投赞成票，如果用户之前投过反对票，则改为赞成票
 func ArticleVote(ctx context.Context, article, user string) error {
-	// 计算文章的投票截止时间
-	cutoff := time.Now().Unix() - OneWeekInSeconds
+	_, err := voteArticle(ctx, article, user, VoteUp)
+	return err
+}
 
-	// 检查是否还可以对文章进行投票
-	score, err := redis.ZScore(ctx, "time:", article)
-	if err != nil {
-		return err
-	}
-	if score < float64(cutoff) {
-		logs.Infow("vote time out", "article", article, "score", score, "cutoff", cutoff)
-		return nil
+// ArticleDownvote 为文章投反对票，如果用户之前投过赞成票，则改为反对票
+func ArticleDownvote(ctx context.Context, article, user string) error {
+	_, err := voteArticle(ctx, article, user, VoteDown)
+	return err
+}
+
+// ArticleUnvote 撤销用户对文章的投票
+func ArticleUnvote(ctx context.Context, article, user string) error {
+	_, err := voteArticle(ctx, article, user, VoteNone)
+	return err
+}
+
+// voteScript 在同一个原子操作里检查投票截止时间、更新投票名单以及调整评分和投票数量
+// KEYS: time:, score:, article:<id>, voted:<id>, downvoted:<id>,
+// [user-voted:<user>, user-downvoted:<user>, karma:, vote-weights:<id>, votes:<bucket>]
+// ARGV: user, direction, cutoff, VoteScore, OneWeekInSeconds, now, karma加权(1或0), 投票分桶的过期时间, [评分算法, 算法参数]
+// 传入可选的键时，同时记录用户的投票、累计发布者的karma、按投票者的karma计算投票权重，并在分桶中累计净票数，
+// 文章散列中的weighted记录加权后的净票数，karma记录文章为发布者带来的karma。
+// 评分算法为空或linear时增量更新评分，其他算法在脚本中根据投票后的票数重新计算，算法参数见rankerArgs
+// 返回值: {-1} 文章不存在，{0} 投票已截止，{1, 赞成票的变化, 反对票的变化, 投票后的赞成票数量} 投票成功，
+// 重复投票时变化为0
+const voteScript = `
+local posted = redis.call("zscore", KEYS[1], KEYS[3])
+if not posted then
+    return {-1}
+end
+posted = tonumber(posted)
+if posted < tonumber(ARGV[3]) then
+    return {0}
+end
+
+local user, direction = ARGV[1], tonumber(ARGV[2])
+local weights = KEYS[9]
+local oldWeight = 1
+if weights then
+    oldWeight = tonumber(redis.call("hget", weights, user) or 1)
+end
+
+local removedUp, removedDown = 0, 0
+if direction ~= 1 then
+    removedUp = redis.call("srem", KEYS[4], user)
+end
+if direction ~= -1 then
+    removedDown = redis.call("srem", KEYS[5], user)
+end
+
+local votes, downvotes = -removedUp, -removedDown
+local delta = (removedDown - removedUp) * oldWeight
+local expireAt = posted + tonumber(ARGV[5])
+if direction ~= 0 then
+    local key = KEYS[4]
+    if direction == -1 then
+        key = KEYS[5]
+    end
+    local added = redis.call("sadd", key, user)
+    redis.call("expireat", key, expireAt)
+
+    if added == 1 then
+        -- 投票权重随投票者karma的对数增长，记录下来以便改票时扣除相同的分值
+        local weight = 1
+        if weights and ARGV[7] == "1" then
+            local karma = tonumber(redis.call("zscore", KEYS[8], user) or 0)
+            weight = 1 + math.log10(1 + math.max(karma, 0))
+            redis.call("hset", weights, user, weight)
+            redis.call("expireat", weights, expireAt)
+        elseif weights then
+            redis.call("hdel", weights, user)
+        end
+
+        delta = delta + direction * weight
+        if direction == 1 then
+            votes = votes + 1
+        else
+            downvotes = downvotes + 1
+        end
+    end
+elseif weights then
+    redis.call("hdel", weights, user)
+end
+
+-- 旧文章没有weighted，第一次投票时按原始净票数初始化
+if weights and delta ~= 0 then
+    if redis.call("hexists", KEYS[3], "weighted") == 0 then
+        local counts = redis.call("hmget", KEYS[3], "votes", "downvotes")
+        redis.call("hset", KEYS[3], "weighted", tonumber(counts[1] or 0) - tonumber(counts[2] or 0))
+    end
+    redis.call("hincrbyfloat", KEYS[3], "weighted", delta)
+end
+local ranker = ARGV[9] or "linear"
+if delta ~= 0 and ranker == "linear" then
+    redis.call("zincrby", KEYS[2], delta * tonumber(ARGV[4]), KEYS[3])
+end
+if votes ~= 0 then
+    redis.call("hincrby", KEYS[3], "votes", votes)
+end
+if downvotes ~= 0 then
+    redis.call("hincrby", KEYS[3], "downvotes", downvotes)
+end
+
+-- 与ranking.go中的Score保持一致，净票数优先使用weighted
+if ranker ~= "linear" and (votes ~= 0 or downvotes ~= 0) then
+    local fields = redis.call("hmget", KEYS[3], "votes", "downvotes", "weighted")
+    local up, down = tonumber(fields[1] or 0), tonumber(fields[2] or 0)
+    local net = tonumber(fields[3] or (up - down))
+    local param = tonumber(ARGV[10])
+    local score = 0
+    if ranker == "gravity" then
+        local hours = math.max(tonumber(ARGV[6]) - posted, 0) / 3600
+        score = (net - 1) / math.pow(hours + 2, param)
+    elseif ranker == "hot" then
+        local order = math.log10(math.max(math.abs(net), 1))
+        local sign = 0
+        if net > 0 then
+            sign = 1
+        elseif net < 0 then
+            sign = -1
+        end
+        local value = (sign * order + (posted - param) / 45000) * 1e7
+        if value < 0 then
+            score = -math.floor(-value + 0.5) / 1e7
+        else
+            score = math.floor(value + 0.5) / 1e7
+        end
+    elseif ranker == "wilson" then
+        local n = up + down
+        if n > 0 then
+            local z2 = param * param
+            local phat = up / n
+            score = (phat + z2 / (2 * n) - param * math.sqrt((phat * (1 - phat) + z2 / (4 * n)) / n)) / (1 + z2 / n)
+        end
+    end
+    redis.call("zadd", KEYS[2], string.format("%.17g", score), KEYS[3])
+end
+
+if #KEYS >= 7 then
+    if direction == 1 then
+        redis.call("zadd", KEYS[6], ARGV[6], KEYS[3])
+    else
+        redis.call("zrem", KEYS[6], KEYS[3])
+    end
+    if direction == -1 then
+        redis.call("zadd", KEYS[7], ARGV[6], KEYS[3])
+    else
+        redis.call("zrem", KEYS[7], KEYS[3])
+    end
+end
+
+if KEYS[10] and votes ~= downvotes then
+    redis.call("zincrby", KEYS[10], votes - downvotes, KEYS[3])
+    redis.call("expire", KEYS[10], ARGV[8])
+end
+
+-- 发布者为自己的文章投票不计入karma
+if KEYS[8] and votes ~= downvotes then
+    local poster = redis.call("hget", KEYS[3], "poster")
+    if poster and poster ~= user then
+        redis.call("zincrby", KEYS[8], votes - downvotes, poster)
+        if redis.call("hexists", KEYS[3], "karma") == 1 then
+            redis.call("hincrby", KEYS[3], "karma", votes - downvotes)
+        else
+            -- 旧文章没有karma，按除发布者以外的净票数初始化，此时票数已包含本次投票
+            local counts = redis.call("hmget", KEYS[3], "votes", "downvotes")
+            redis.call("hset", KEYS[3], "karma", tonumber(counts[1] or 0) - tonumber(counts[2] or 0) - 1)
+        end
+    end
+end
+return {1, votes, downvotes, tonumber(redis.call("hget", KEYS[3], "votes") or 0)}
+`
+
+// voteResult 投票脚本的返回值
+type voteResult struct {
+	status    int64
+	votes     int64 // 赞成票的变化
+	downvotes int64 // 反对票的变化
+	total     int64 // 投票后的赞成票数量
+}
+
+func parseVoteResult(res interface{}) voteResult {
+	values := res.([]interface{})
+	result := voteResult{status: values[0].(int64)}
+	if len(values) >= 4 {
+		result.votes, result.downvotes, result.total = values[1].(int64), values[2].(int64), values[3].(int64)
 	}
+	return result
+}
 
+// voteArticle 执行投票并返回投票脚本的结果，调用者可以据此判断投票是否真正发生了变化
+func voteArticle(ctx context.Context, article, user string, direction VoteDirection) (voteResult, error) {
 	split := strings.Split(article, ":")
 	if len(split) != 2 {
-		return logs.NewErrorw("invalid article format", article)
+		return voteResult{}, fmt.Errorf("%w: %s", ErrInvalidArticle, article)
+	}
+
+	// 计算文章的投票截止时间
+	now := time.Now().Unix()
+	cutoff := now - OneWeekInSeconds
+
+	// 评分在投票脚本中计算，避免在脚本外重新计算时读到旧的票数覆盖较新的评分
+	ranker, err := GetRanker(ctx)
+	if err != nil {
+		return voteResult{}, err
 	}
+	rankArgs, inScript := rankerArgs(ranker)
 
 	articleId := split[1]
-	sadd, err := redis.SAdd(ctx, "voted:"+articleId, user)
+	keys := []string{"time:", "score:", article, "voted:" + articleId, "downvoted:" + articleId,
+		"user-voted:" + user, "user-downvoted:" + user, "karma:", "vote-weights:" + articleId, trendingBucket(now)}
+	weighted := 0
+	if KARMAWEIGHTED {
+		weighted = 1
+	}
+	args := append([]interface{}{user, int(direction), cutoff, VoteScore, OneWeekInSeconds, now, weighted,
+		OneWeekInSeconds + TrendingBucketSeconds}, rankArgs...)
+	res, err := evalScript(ctx, voteScript, keys, args...)
 	if err != nil {
-		return err
+		return voteResult{}, err
 	}
-	// 如果用户第一次为这篇文章投票，增加这篇文章的评分和投票数量
-	if sadd > 0 {
-		if _, err := redis.ZIncrBy(ctx, "score:", VoteScore, article); err != nil {
-			return err
-		}
 
-		if _, err := redis.HIncrBy(ctx, article, "votes", 1); err != nil {
-			return err
-		}
+	result := parseVoteResult(res)
+	switch result.status {
+	case -1:
+		return result, fmt.Errorf("%w: %s", ErrArticleNotFound, article)
+	case 0:
+		logs.Infow("vote time out", "article", article, "cutoff", cutoff)
+		return result, fmt.Errorf("%w: %s", ErrVotingClosed, article)
+	}
+
+	// 使用脚本返回的票数，并发投票时每个里程碑只会由刚好达到它的那一票发布
+	if result.votes > 0 {
+		publishMilestone(ctx, articleId, result.total)
+	}
+
+	// 脚本不支持的评分算法只能在投票后重新计算
+	if !inScript && (result.votes != 0 || result.downvotes != 0) {
+		return result, Rescore(ctx, ranker, article)
 	}
 
-	return nil
+	return result, nil
 }
 
 func PostArticle(ctx context.Context, user, title, link string) (string, error) {
@@ -62,37 +288,56 @@ func PostArticle(ctx context.Context, user, title, link string) (string, error)
 	}
 	articleId := strconv.Itoa(int(incrId))
 
-	// 将发布的文章的用户添加到文章的已投票用户名单中
-	// 并将这个名单的过期时间设置为一周
-	voted := "voted:" + articleId
-	_, _ = redis.SAdd(ctx, voted, user)
-	_, _ = redis.Expire(ctx, voted, OneWeekInSeconds*time.Second)
+	ranker, err := GetRanker(ctx)
+	if err != nil {
+		return "", err
+	}
 
-	// 将文章信息存储到一个散列里面
 	now := time.Now().Unix()
 	article := "article:" + articleId
-	if _, err := redis.HSet(ctx, article, "title", title, "link", link, "poster", user, "time", now, "votes", 1); err != nil {
-		logs.Warnw("failed to HSet article", "error", err)
-		return "", err
-	}
+	voted := "voted:" + articleId
+	score := ranker.Score(&Article{Id: articleId, Time: now, Votes: 1}, now)
 
-	// 将文章添加到根据发布时间排序的有序集合和根据评分排序的有序集合中
-	if _, err := redis.ZAdd(ctx, "score:", float64(now+VoteScore), article); err != nil {
-		logs.Warnw("failed to ZAdd article score", "error", err)
-		return "", err
-	}
+	// 在同一个事务里写入文章的所有数据，避免只写入了一部分
+	err = redis.Watch(ctx, func(tx *redis.Tx) error {
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// 将发布的文章的用户添加到文章的已投票用户名单中
+			// 并将这个名单的过期时间设置为一周
+			pipe.SAdd(ctx, voted, user)
+			pipe.Expire(ctx, voted, OneWeekInSeconds*time.Second)
+
+			// 将文章信息存储到一个散列里面
+			pipe.HSet(ctx, article, "title", title, "link", link, "poster", user, "time", now, "votes", 1, "downvotes", 0,
+				"weighted", 1, "karma", 0)
 
-	if _, err := redis.ZAdd(ctx, "time:", float64(now), article); err != nil {
-		logs.Warnw("failed to ZAdd article time", "error", err)
+			// 将文章添加到根据发布时间排序的有序集合和根据评分排序的有序集合中
+			pipe.ZAdd(ctx, "score:", redis.Z{Score: score, Member: article})
+			pipe.ZAdd(ctx, "time:", redis.Z{Score: float64(now), Member: article})
+
+			// 将标题加入全文索引
+			indexTitle(ctx, pipe, article, "", title)
+
+			// 记录用户发布和投票过的文章
+			pipe.ZAdd(ctx, "posted:"+user, redis.Z{Score: float64(now), Member: article})
+			pipe.ZAdd(ctx, "user-voted:"+user, redis.Z{Score: float64(now), Member: article})
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		logs.Warnw("failed to post article", "article", article, "error", err)
 		return "", err
 	}
 
+	publishEvent(ctx, Event{Type: EventPosted, Article: articleId, Title: title, Poster: user}, EventsChannel)
+
 	return articleId, nil
 }
 
-func GetArticle(ctx context.Context, page int64, order string) ([]map[string]string, error) {
-	if order == "" {
-		order = "score:"
+func GetArticle(ctx context.Context, page int64, order string) ([]*Article, error) {
+	order, err := resolveOrder(ctx, order)
+	if err != nil {
+		return nil, err
 	}
 
 	// 设置获取文章的起始索引和结束索引
@@ -104,50 +349,90 @@ func GetArticle(ctx context.Context, page int64, order string) ([]map[string]str
 		return nil, err
 	}
 
-	articles := make([]map[string]string, 0)
-	for _, id := range ids {
-		data, _ := redis.HGetAll(ctx, id)
-		data["id"] = id
-		articles = append(articles, data)
-	}
-
-	return articles, nil
+	// 使用流水线一次性获取整页文章
+	return fetchListedArticles(ctx, order, ids)
 }
 
 func AddRemoveGroups(ctx context.Context, articleId string, toAdd, toRemove []string) error {
 	article := "article:" + articleId
 
-	// 将文章添加到它所属的群组里面
-	for _, group := range toAdd {
-		_, _ = redis.SAdd(ctx, "group:"+group, article)
+	err := watchWithRetry(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, article).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("%w: %s", ErrArticleNotFound, article)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// 将文章添加到它所属的群组里面，同时记录文章所属的群组，方便删除文章时清理
+			for _, group := range toAdd {
+				pipe.SAdd(ctx, "group:"+group, article)
+				pipe.SAdd(ctx, "groups:"+articleId, group)
+				pipe.SAdd(ctx, "groups:", group)
+			}
+
+			// 从群组里面移除文章
+			for _, group := range toRemove {
+				pipe.SRem(ctx, "group:"+group, article)
+				pipe.SRem(ctx, "groups:"+articleId, group)
+			}
+			return nil
+		})
+		return err
+	}, article)
+	if err != nil {
+		logs.Warnw("failed to change article groups", "article", article, "error", err)
+		return err
 	}
 
-	// 从群组里面移除文章
-	for _, group := range toRemove {
-		_, _ = redis.SRem(ctx, "group:"+group, article)
+	for _, group := range toAdd {
+		publishEvent(ctx, Event{Type: EventGroupAdded, Article: articleId, Group: group}, EventsChannel, GroupChannel(group))
 	}
 
-	return nil
+	// 群组成员发生变化，让缓存的排序结果和查询结果失效
+	changed := make([]string, 0, len(toAdd)+len(toRemove))
+	changed = append(append(changed, toAdd...), toRemove...)
+	return invalidateGroups(ctx, changed...)
+}
+
+func GetGroupArticles(ctx context.Context, group, order string, page int64) ([]*Article, error) {
+	key, err := groupOrderKey(ctx, group, order)
+	if err != nil {
+		return nil, err
+	}
+	return GetArticle(ctx, page, key)
 }
 
-func GetGroupArticles(ctx context.Context, group, order string, page int64) ([]map[string]string, error) {
-	if order == "" {
-		order = "score:"
+// groupOrderKey 返回按order排序的群组文章有序集合，不存在时通过交集计算并缓存
+func groupOrderKey(ctx context.Context, group, order string) (string, error) {
+	order, err := resolveOrder(ctx, order)
+	if err != nil {
+		return "", err
 	}
 
 	// 为每个群组的每种排列顺序都创建一个键
 	key := order + group
-	exists, _ := redis.Exists(ctx, key)
+	exists, err := redis.Exists(ctx, key)
+	if err != nil {
+		return "", err
+	}
 	if !exists {
 		// 根据评分或者发布时间对群组文章进行排序
-		res, _ := redis.ZInterStore(ctx, key, []string{"group:" + group, order}, []float64{}, "")
+		res, err := redis.ZInterStore(ctx, key, []string{"group:" + group, order}, []float64{}, "")
+		if err != nil {
+			return "", err
+		}
 		if res <= 0 {
 			logs.Warnw("zinterstore return 0", "key", key, "group", group, "order", order)
 		}
 
 		// 缓存60s
-		_, _ = redis.Expire(ctx, key, 60*time.Second)
+		if _, err := redis.Expire(ctx, key, 60*time.Second); err != nil {
+			return "", err
+		}
 	}
 
-	return GetArticle(ctx, page, key)
+	return key, nil
 }
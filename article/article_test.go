@@ -1,11 +1,16 @@
 package article
 
 import (
+	"bytes"
 	"context"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/chaos-io/chaos/logs"
 	"github.com/chaos-io/chaos/redis"
+	"github.com/segmentio/ksuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -42,3 +47,706 @@ func Test(t *testing.T) {
 	// _, err = redis.Do(ctx, "FLUSHDB")
 	// assert.NoError(t, err)
 }
+
+func TestArticleDownvote(t *testing.T) {
+	ctx := context.Background()
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+
+	before, err := redis.ZScore(ctx, "score:", articleKey)
+	assert.NoError(t, err)
+
+	err = ArticleDownvote(ctx, articleKey, "other_user")
+	assert.NoError(t, err)
+	score, err := redis.ZScore(ctx, "score:", articleKey)
+	assert.NoError(t, err)
+	assert.Equal(t, before-VoteScore, score)
+
+	// 反对票改为赞成票
+	err = ArticleVote(ctx, articleKey, "other_user")
+	assert.NoError(t, err)
+	score, err = redis.ZScore(ctx, "score:", articleKey)
+	assert.NoError(t, err)
+	assert.Equal(t, before+VoteScore, score)
+
+	downvotes, err := redis.HGet(ctx, articleKey, "downvotes")
+	assert.NoError(t, err)
+	assert.Equal(t, "0", downvotes)
+
+	// 撤销投票
+	err = ArticleUnvote(ctx, articleKey, "other_user")
+	assert.NoError(t, err)
+	votes, err := redis.HGet(ctx, articleKey, "votes")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", votes)
+}
+
+func TestUpdateDeleteArticle(t *testing.T) {
+	ctx := context.Background()
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+
+	err = UpdateArticle(ctx, articleId, "new title", "")
+	assert.NoError(t, err)
+	title, err := redis.HGet(ctx, articleKey, "title")
+	assert.NoError(t, err)
+	assert.Equal(t, "new title", title)
+
+	err = AddRemoveGroups(ctx, articleId, []string{"new-group"}, []string{})
+	assert.NoError(t, err)
+
+	// 模拟引入反向索引之前直接写入的群组成员
+	legacy := "legacy-" + ksuid.New().String()
+	_, err = redis.SAdd(ctx, "group:"+legacy, articleKey)
+	assert.NoError(t, err)
+	assert.NoError(t, redis.Del(ctx, "groups-indexed:"))
+
+	err = DeleteArticle(ctx, articleId)
+	assert.NoError(t, err)
+
+	exists, err := redis.Exists(ctx, articleKey)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = redis.Exists(ctx, "group:"+legacy)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	err = DeleteArticle(ctx, articleId)
+	assert.ErrorIs(t, err, ErrArticleNotFound)
+	err = UpdateArticle(ctx, articleId, "new title", "")
+	assert.ErrorIs(t, err, ErrArticleNotFound)
+}
+
+func TestUpdateWhileVoting(t *testing.T) {
+	ctx := context.Background()
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+
+	// 投票会修改被监视的文章散列，修改文章时需要重试而不是失败
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			_ = ArticleVote(ctx, articleKey, ksuid.New().String())
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, UpdateArticle(ctx, articleId, "title "+strconv.Itoa(i), ""))
+	}
+	<-done
+}
+
+func TestDeleteTrendingArticle(t *testing.T) {
+	ctx := context.Background()
+
+	articleId, err := PostArticle(ctx, "username", "trending title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+	assert.NoError(t, ArticleVote(ctx, articleKey, "user_a"))
+
+	// 先生成缓存的热门列表，再删除文章
+	_, err = GetArticle(ctx, 1, "trending:hour:")
+	assert.NoError(t, err)
+	assert.NoError(t, DeleteArticle(ctx, articleId))
+
+	_, err = redis.ZScore(ctx, trendingBucket(time.Now().Unix()), articleKey)
+	assert.Error(t, err)
+	_, err = redis.ZScore(ctx, "trending:hour:", articleKey)
+	assert.Error(t, err)
+
+	// 列表中残留的文章被跳过，不会让整页失败
+	_, err = redis.ZAdd(ctx, "trending:hour:", 1e9, articleKey)
+	assert.NoError(t, err)
+	articles, err := GetArticle(ctx, 1, "trending:hour:")
+	assert.NoError(t, err)
+	for _, article := range articles {
+		assert.NotEqual(t, articleId, article.Id)
+	}
+}
+
+func TestComments(t *testing.T) {
+	ctx := context.Background()
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+
+	commentId, err := PostComment(ctx, articleId, "", "other_user", "first")
+	assert.NoError(t, err)
+	replyId, err := PostComment(ctx, articleId, commentId, "username", "reply")
+	assert.NoError(t, err)
+
+	err = CommentVote(ctx, replyId, "other_user")
+	assert.NoError(t, err)
+
+	comments, err := GetComments(ctx, articleId, "", 1, "score:", 1)
+	assert.NoError(t, err)
+	assert.Len(t, comments, 1)
+	assert.Len(t, comments[0].Replies, 1)
+	assert.Equal(t, int64(2), comments[0].Replies[0].Votes)
+
+	_, err = GetComments(ctx, articleId, "", 1, "votes", 0)
+	assert.ErrorIs(t, err, ErrInvalidOrder)
+
+	// 回复不存在的文章不会消耗评论Id
+	lastId, err := redis.Get(ctx, "comment:")
+	assert.NoError(t, err)
+	_, err = PostComment(ctx, "missing-"+articleId, "", "other_user", "lost")
+	assert.ErrorIs(t, err, ErrArticleNotFound)
+	nextId, err := redis.Get(ctx, "comment:")
+	assert.NoError(t, err)
+	assert.Equal(t, lastId, nextId)
+
+	comment, err := redis.HGet(ctx, "article:"+articleId, "comments")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", comment)
+
+	err = DeleteArticle(ctx, articleId)
+	assert.NoError(t, err)
+	exists, err := redis.Exists(ctx, "comment:"+replyId)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestGetQueryArticles(t *testing.T) {
+	ctx := context.Background()
+
+	a, err := PostArticle(ctx, "username", "in a", "https://g.cn")
+	assert.NoError(t, err)
+	b, err := PostArticle(ctx, "username", "in b and c", "https://g.cn")
+	assert.NoError(t, err)
+
+	assert.NoError(t, AddRemoveGroups(ctx, a, []string{"query-a"}, nil))
+	assert.NoError(t, AddRemoveGroups(ctx, b, []string{"query-b", "query-c"}, nil))
+
+	query := GroupQuery{Any: []string{"query-a", "query-b"}, None: []string{"query-c"}}
+	articles, err := GetQueryArticles(ctx, query, "score:", 1)
+	assert.NoError(t, err)
+	assert.Len(t, articles, 1)
+	assert.Equal(t, a, articles[0].Id)
+
+	// 群组成员变化后缓存的查询结果立即失效
+	assert.NoError(t, AddRemoveGroups(ctx, b, nil, []string{"query-c"}))
+	articles, err = GetQueryArticles(ctx, query, "score:", 1)
+	assert.NoError(t, err)
+	assert.Len(t, articles, 2)
+}
+
+func TestUserVotes(t *testing.T) {
+	ctx := context.Background()
+	user := "history_user"
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+
+	assert.NoError(t, ArticleDownvote(ctx, articleKey, user))
+	downvoted, err := GetUserVotedArticles(ctx, user, VoteDown, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, articleId, downvoted[0].Id)
+
+	posted, err := GetUserPostedArticles(ctx, "username", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, articleId, posted[0].Id)
+
+	count, err := DeleteUserVotes(ctx, user)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	downvotes, err := redis.HGet(ctx, articleKey, "downvotes")
+	assert.NoError(t, err)
+	assert.Equal(t, "0", downvotes)
+}
+
+func TestMissingListedArticle(t *testing.T) {
+	ctx := context.Background()
+	user := "voter-" + ksuid.New().String()
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+	assert.NoError(t, ArticleVote(ctx, articleKey, user))
+
+	// 文章散列丢失后，列表跳过该文章并从索引中删除它
+	assert.NoError(t, redis.Del(ctx, articleKey))
+	voted, err := GetUserVotedArticles(ctx, user, VoteUp, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, voted)
+
+	_, err = redis.ZScore(ctx, "user-voted:"+user, articleKey)
+	assert.Error(t, err)
+}
+
+func TestGuardedVote(t *testing.T) {
+	ctx := context.Background()
+	defer func() { VOTELIMITS = VoteLimits{Window: time.Hour} }()
+	VOTELIMITS = VoteLimits{Window: time.Minute, PerIP: 1}
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+	ip := ksuid.New().String()
+
+	assert.NoError(t, GuardedVote(ctx, articleKey, "user_a", ip, VoteUp))
+	err = GuardedVote(ctx, articleKey, "user_b", ip, VoteUp)
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	VOTELIMITS = VoteLimits{Window: time.Minute, MinAccountAge: time.Hour}
+	user := ksuid.New().String()
+	err = GuardedVote(ctx, articleKey, user, ip, VoteUp)
+	assert.ErrorIs(t, err, ErrAccountTooNew)
+
+	assert.NoError(t, RegisterUser(ctx, user, time.Now().Add(-2*time.Hour)))
+	assert.ErrorIs(t, RegisterUser(ctx, user, time.Now()), ErrUserExists)
+	assert.NoError(t, GuardedVote(ctx, articleKey, user, ip, VoteUp))
+}
+
+func TestDetectVotingRings(t *testing.T) {
+	ctx := context.Background()
+	poster := ksuid.New().String()
+	ring := []string{"ring-a-" + poster, "ring-b-" + poster, "ring-c-" + poster}
+	loyal := "loyal-" + poster
+
+	articles := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		articleId, err := PostArticle(ctx, poster, "a title", "https://g.cn")
+		assert.NoError(t, err)
+		articleKey := "article:" + articleId
+		articles = append(articles, articleKey)
+
+		for _, user := range append(ring, loyal) {
+			assert.NoError(t, GuardedVote(ctx, articleKey, user, "", VoteUp))
+		}
+		// 忠实读者的投票时间与团伙相隔很远
+		_, err = redis.ZAdd(ctx, "user-voted:"+loyal, float64(time.Now().Unix()-int64(i+1)*3600), articleKey)
+		assert.NoError(t, err)
+	}
+
+	rings, err := DetectVotingRings(ctx, 2, 3, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, ring, rings[poster])
+
+	votes, err := redis.HGet(ctx, articles[0], "votes")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", votes)
+
+	// 被标记的用户的赞成票被静默记录，撤销投票照常处理
+	assert.NoError(t, GuardedVote(ctx, articles[0], ring[0], "", VoteUp))
+	assert.NoError(t, GuardedVote(ctx, articles[0], ring[0], "", VoteNone))
+	pipe := redis.Pipeline()
+	flaggedVote := pipe.SIsMember(ctx, "flagged-votes:"+strings.TrimPrefix(articles[0], "article:"), ring[0])
+	_, err = pipe.Exec(ctx)
+	assert.NoError(t, err)
+	assert.False(t, flaggedVote.Val())
+}
+
+func TestFindRings(t *testing.T) {
+	coVotes := map[votePair]int64{
+		{a: "a", b: "b"}: 3,
+		{a: "b", b: "c"}: 2,
+		{a: "c", b: "d"}: 1,
+		{a: "x", b: "y"}: 5,
+	}
+	assert.Equal(t, [][]string{{"a", "b", "c"}}, findRings(coVotes, 2, 3))
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"x", "y"}}, findRings(coVotes, 2, 2))
+}
+
+func TestKarma(t *testing.T) {
+	ctx := context.Background()
+	poster := ksuid.New().String()
+
+	before, err := GetKarma(ctx, poster)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), before)
+
+	articleId, err := PostArticle(ctx, poster, "a title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+
+	assert.NoError(t, ArticleVote(ctx, articleKey, "user_a"))
+	assert.NoError(t, ArticleVote(ctx, articleKey, "user_b"))
+	assert.NoError(t, ArticleDownvote(ctx, articleKey, "user_c"))
+
+	karma, err := GetKarma(ctx, poster)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), karma)
+
+	top, err := TopUsers(ctx, 10)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, top)
+
+	// 删除文章时扣除文章带来的karma
+	assert.NoError(t, DeleteArticle(ctx, articleId))
+	karma, err = GetKarma(ctx, poster)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), karma)
+}
+
+func TestGetRelatedArticles(t *testing.T) {
+	ctx := context.Background()
+	reader := ksuid.New().String()
+
+	a, err := PostArticle(ctx, "poster-a-"+reader, "a title", "https://g.cn")
+	assert.NoError(t, err)
+	b, err := PostArticle(ctx, "poster-b-"+reader, "another title", "https://g.cn")
+	assert.NoError(t, err)
+
+	assert.NoError(t, ArticleVote(ctx, "article:"+a, reader))
+	assert.NoError(t, ArticleVote(ctx, "article:"+b, reader))
+
+	assert.NoError(t, RefreshRelated(ctx, a))
+	related, err := GetRelatedArticles(ctx, a, 10)
+	assert.NoError(t, err)
+
+	ids := make([]string, 0, len(related))
+	for _, article := range related {
+		ids = append(ids, article.Id)
+	}
+	assert.Contains(t, ids, b)
+	assert.NotContains(t, ids, a)
+
+	// 同一个发布者的两篇文章没有读者投票时不相关，空结果也会被缓存
+	poster := ksuid.New().String()
+	c, err := PostArticle(ctx, poster, "a title", "https://g.cn")
+	assert.NoError(t, err)
+	d, err := PostArticle(ctx, poster, "another title", "https://g.cn")
+	assert.NoError(t, err)
+
+	related, err = GetRelatedArticles(ctx, c, 10)
+	assert.NoError(t, err)
+	for _, article := range related {
+		assert.NotEqual(t, d, article.Id)
+	}
+	cached, err := redis.Exists(ctx, "related:"+c)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+
+	// 发布者撤销了对自己文章的投票后不在共同投票人数中，不能再扣除
+	assert.NoError(t, ArticleUnvote(ctx, "article:"+c, poster))
+	assert.NoError(t, ArticleVote(ctx, "article:"+c, reader))
+	assert.NoError(t, ArticleVote(ctx, "article:"+d, reader))
+	assert.NoError(t, RefreshRelated(ctx, c))
+	related, err = GetRelatedArticles(ctx, c, 10)
+	assert.NoError(t, err)
+	ids = ids[:0]
+	for _, article := range related {
+		ids = append(ids, article.Id)
+	}
+	assert.Contains(t, ids, d)
+
+	// 不存在的文章不会缓存空结果
+	_, err = GetRelatedArticles(ctx, "missing-"+poster, 10)
+	assert.ErrorIs(t, err, ErrArticleNotFound)
+	cached, err = redis.Exists(ctx, "related:missing-"+poster)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+}
+
+func TestGroupAdmin(t *testing.T) {
+	ctx := context.Background()
+	name := ksuid.New().String()
+	newName := ksuid.New().String()
+
+	assert.NoError(t, CreateGroup(ctx, name, "username", "a group"))
+	assert.ErrorIs(t, CreateGroup(ctx, name, "username", "a group"), ErrGroupExists)
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	assert.NoError(t, AddRemoveGroups(ctx, articleId, []string{name}, nil))
+	assert.ErrorIs(t, AddRemoveGroups(ctx, "not-exists", []string{name}, nil), ErrArticleNotFound)
+
+	group, err := GetGroup(ctx, name)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), group.Articles)
+	assert.Equal(t, "a group", group.Description)
+
+	assert.NoError(t, RenameGroup(ctx, name, newName))
+	groups, err := GetArticleGroups(ctx, articleId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{newName}, groups)
+
+	assert.NoError(t, DeleteGroup(ctx, newName))
+	_, err = GetGroup(ctx, newName)
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+	groups, err = GetArticleGroups(ctx, articleId)
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestLegacyGroup(t *testing.T) {
+	ctx := context.Background()
+	name := "legacy-" + ksuid.New().String()
+	newName := "legacy-" + ksuid.New().String()
+
+	// 引入群组名单之前隐式创建的群组只有group:<name>
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	_, err = redis.SAdd(ctx, "group:"+name, "article:"+articleId)
+	assert.NoError(t, err)
+
+	group, err := GetGroup(ctx, name)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), group.Articles)
+
+	assert.NoError(t, redis.Del(ctx, "groups-indexed:"))
+	groups, err := ListGroups(ctx)
+	assert.NoError(t, err)
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+	assert.Contains(t, names, name)
+
+	assert.NoError(t, RenameGroup(ctx, name, newName))
+	assert.NoError(t, DeleteGroup(ctx, newName))
+	_, err = GetGroup(ctx, newName)
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	group := ksuid.New().String()
+
+	sub, err := Subscribe(ctx, group)
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	assert.NoError(t, AddRemoveGroups(ctx, articleId, []string{group}, nil))
+
+	select {
+	case event := <-sub.Events():
+		assert.Equal(t, EventGroupAdded, event.Type)
+		assert.Equal(t, articleId, event.Article)
+		assert.Equal(t, group, event.Group)
+	case <-ctx.Done():
+		t.Fatal("no event received")
+	}
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	ctx := context.Background()
+	group := ksuid.New().String()
+
+	sub, err := Subscribe(ctx, group)
+	assert.NoError(t, err)
+
+	// 没有人读取事件时接收协程阻塞在发送上，Close之后应该退出并关闭通道
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	assert.NoError(t, AddRemoveGroups(ctx, articleId, []string{group}, nil))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, sub.Close())
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("events channel not closed")
+		}
+	}
+}
+
+func TestVoteMilestone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	group := ksuid.New().String()
+
+	articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+	assert.NoError(t, err)
+	assert.NoError(t, AddRemoveGroups(ctx, articleId, []string{group}, nil))
+
+	sub, err := Subscribe(ctx, group)
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	// 发布者的1票加上9个读者的票达到第一个里程碑
+	for i := 0; i < 9; i++ {
+		assert.NoError(t, ArticleVote(ctx, "article:"+articleId, ksuid.New().String()))
+	}
+
+	select {
+	case event := <-sub.Events():
+		assert.Equal(t, EventMilestone, event.Type)
+		assert.Equal(t, int64(10), event.Votes)
+	case <-ctx.Done():
+		t.Fatal("no milestone event received")
+	}
+}
+
+func TestExportImportArticles(t *testing.T) {
+	ctx := context.Background()
+
+	articleId, err := PostArticle(ctx, "username", "exported title", "https://g.cn")
+	assert.NoError(t, err)
+	assert.NoError(t, AddRemoveGroups(ctx, articleId, []string{"export-group"}, nil))
+
+	buf := &bytes.Buffer{}
+	exported, err := ExportArticles(ctx, buf, true)
+	assert.NoError(t, err)
+	assert.Greater(t, exported, 0)
+
+	assert.NoError(t, DeleteArticle(ctx, articleId))
+
+	// 重复导入的结果相同
+	data := buf.Bytes()
+	for i := 0; i < 2; i++ {
+		imported, err := ImportArticles(ctx, bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, exported, imported)
+	}
+
+	title, err := redis.HGet(ctx, "article:"+articleId, "title")
+	assert.NoError(t, err)
+	assert.Equal(t, "exported title", title)
+
+	groups, err := GetArticleGroups(ctx, articleId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"export-group"}, groups)
+}
+
+func TestImportWithoutVoters(t *testing.T) {
+	ctx := context.Background()
+
+	articleId, err := PostArticle(ctx, "username", "voted title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+	voter := "voter-" + ksuid.New().String()
+	assert.NoError(t, ArticleVote(ctx, articleKey, voter))
+
+	// 不包含投票名单的导出文件覆盖已有投票的文章
+	buf := &bytes.Buffer{}
+	_, err = ExportArticles(ctx, buf, false)
+	assert.NoError(t, err)
+	_, err = ImportArticles(ctx, bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+
+	pipe := redis.Pipeline()
+	isMember := pipe.SIsMember(ctx, "voted:"+articleId, voter)
+	_, err = pipe.Exec(ctx)
+	assert.NoError(t, err)
+	assert.True(t, isMember.Val())
+	_, err = redis.ZScore(ctx, "user-voted:"+voter, articleKey)
+	assert.NoError(t, err)
+}
+
+func TestImportKarma(t *testing.T) {
+	ctx := context.Background()
+	poster := "poster-" + ksuid.New().String()
+
+	articleId, err := PostArticle(ctx, poster, "karma title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+	assert.NoError(t, ArticleVote(ctx, articleKey, "voter-"+ksuid.New().String()))
+
+	buf := &bytes.Buffer{}
+	_, err = ExportArticles(ctx, buf, true)
+	assert.NoError(t, err)
+
+	// 重复导入不会重复计入karma，删除后导入恢复发布者的karma
+	for i := 0; i < 2; i++ {
+		_, err = ImportArticles(ctx, bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err)
+		karma, err := GetKarma(ctx, poster)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), karma)
+	}
+
+	assert.NoError(t, DeleteArticle(ctx, articleId))
+	karma, err := GetKarma(ctx, poster)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), karma)
+
+	_, err = ImportArticles(ctx, bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	karma, err = GetKarma(ctx, poster)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), karma)
+}
+
+func TestArchiveArticles(t *testing.T) {
+	ctx := context.Background()
+
+	articleId, err := PostArticle(ctx, "username", "archived title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+
+	// 把发布时间改到两周前，并调低归档进度，模拟导入的旧文章
+	posted := time.Now().Unix() - 2*OneWeekInSeconds
+	_, err = redis.ZAdd(ctx, "time:", float64(posted), articleKey)
+	assert.NoError(t, err)
+	assert.NoError(t, lowerArchiveWatermark(ctx, posted))
+
+	buf := &bytes.Buffer{}
+	opts := ArchiveOptions{ColdAfter: 10 * 24 * time.Hour, Export: buf}
+	frozen, moved, err := ArchiveArticles(ctx, opts)
+	assert.NoError(t, err)
+	assert.Greater(t, frozen, 0)
+	assert.Greater(t, moved, 0)
+	assert.Contains(t, buf.String(), `"id":"`+articleId+`"`)
+
+	exists, err := redis.Exists(ctx, "cold:"+articleKey)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = redis.Exists(ctx, articleKey)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// 再次归档不会重复导出已经移动的文章
+	buf.Reset()
+	_, _, err = ArchiveArticles(ctx, opts)
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), `"id":"`+articleId+`"`)
+}
+
+func TestRunArchiver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	articleId, err := PostArticle(ctx, "username", "frozen title", "https://g.cn")
+	assert.NoError(t, err)
+	articleKey := "article:" + articleId
+
+	posted := time.Now().Unix() - OneWeekInSeconds - 60
+	_, err = redis.ZAdd(ctx, "time:", float64(posted), articleKey)
+	assert.NoError(t, err)
+	assert.NoError(t, lowerArchiveWatermark(ctx, posted))
+	_, err = redis.SAdd(ctx, "flagged-votes:"+articleId, "flagged-user")
+	assert.NoError(t, err)
+	// 冻结前把评分改成与线性算法不同的值，重新计算评分时不应覆盖冻结的评分
+	_, err = redis.ZAdd(ctx, "score:", 1, articleKey)
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		RunArchiver(ctx, 10*time.Millisecond, ArchiveOptions{})
+	}()
+	<-done
+
+	archived, err := redis.HGet(context.Background(), articleKey, "archived")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", archived)
+	for _, key := range []string{"voted:", "vote-weights:", "flagged-votes:"} {
+		exists, err := redis.Exists(context.Background(), key+articleId)
+		assert.NoError(t, err)
+		assert.False(t, exists, key)
+	}
+
+	_, err = RescoreAll(context.Background(), LinearRanker{}, 100)
+	assert.NoError(t, err)
+	score, err := redis.ZScore(context.Background(), "score:", articleKey)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), score)
+}
@@ -0,0 +1,286 @@
+package article
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const CommentsPrePage = 25
+
+var (
+	// ErrCommentNotFound 评论散列不存在
+	ErrCommentNotFound = errors.New("comment not found")
+	// ErrInvalidOrder 评论的排序方式不是score:或time:
+	ErrInvalidOrder = errors.New("invalid comment order")
+)
+
+// Comment 评论散列comment:<id>的结构化表示
+// 文章的直接回复保存在replies:score:article:<id>和replies:time:article:<id>两个有序集合里，
+// 评论的回复保存在replies:score:comment:<id>和replies:time:comment:<id>里。
+type Comment struct {
+	Id        string     `json:"id"`
+	Article   string     `json:"article"`
+	Parent    string     `json:"parent"`
+	Author    string     `json:"author"`
+	Text      string     `json:"text"`
+	Time      int64      `json:"time"`
+	Votes     int64      `json:"votes"`
+	Downvotes int64      `json:"downvotes"`
+	Score     float64    `json:"score"`
+	Replies   []*Comment `json:"replies,omitempty"`
+}
+
+// Key 返回评论散列的键
+func (c *Comment) Key() string {
+	return "comment:" + c.Id
+}
+
+// DecodeComment 将评论散列解码成Comment，key为comment:<id>
+func DecodeComment(key string, data map[string]string) (*Comment, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCommentNotFound, key)
+	}
+
+	comment := &Comment{
+		Id:      strings.TrimPrefix(key, "comment:"),
+		Article: data["article"],
+		Parent:  data["parent"],
+		Author:  data["author"],
+		Text:    data["text"],
+	}
+
+	var err error
+	if comment.Time, err = parseInt(data, "time"); err != nil {
+		return nil, fmt.Errorf("comment %s: %w", key, err)
+	}
+	if comment.Votes, err = parseInt(data, "votes"); err != nil {
+		return nil, fmt.Errorf("comment %s: %w", key, err)
+	}
+	if comment.Downvotes, err = parseInt(data, "downvotes"); err != nil {
+		return nil, fmt.Errorf("comment %s: %w", key, err)
+	}
+
+	return comment, nil
+}
+
+// PostComment 发表评论，parentId为空表示直接回复文章，否则回复parentId对应的评论
+func PostComment(ctx context.Context, articleId, parentId, user, text string) (string, error) {
+	article := "article:" + articleId
+	parent := article
+	if parentId != "" {
+		parent = "comment:" + parentId
+	}
+
+	// 先确认被回复的文章和评论存在，无效的请求不会消耗评论Id
+	exists, err := redis.Exists(ctx, article)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("%w: %s", ErrArticleNotFound, article)
+	}
+	if parentId != "" {
+		owner, err := redis.HGet(ctx, parent, "article")
+		if err := checkParentOwner(err, owner, parent, articleId); err != nil {
+			return "", err
+		}
+	}
+
+	// 生成一个新的评论Id
+	incrId, err := redis.Incr(ctx, "comment:")
+	if err != nil {
+		return "", err
+	}
+	commentId := strconv.Itoa(int(incrId))
+
+	now := time.Now().Unix()
+	comment := "comment:" + commentId
+	voted := "comment-voted:" + commentId
+
+	err = watchWithRetry(ctx, func(tx *redis.Tx) error {
+		// 生成Id之后文章或评论可能已被删除，在事务中再确认一次
+		exists, err := tx.Exists(ctx, article).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("%w: %s", ErrArticleNotFound, article)
+		}
+		if parentId != "" {
+			owner, err := tx.HGet(ctx, parent, "article").Result()
+			if err := checkParentOwner(err, owner, parent, articleId); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			// 和文章一样，评论者默认为自己的评论投一票
+			pipe.SAdd(ctx, voted, user)
+			pipe.Expire(ctx, voted, OneWeekInSeconds*time.Second)
+
+			pipe.HSet(ctx, comment, "article", articleId, "parent", parentId, "author", user, "text", text,
+				"time", now, "votes", 1, "downvotes", 0)
+			pipe.ZAdd(ctx, "replies:score:"+parent, redis.Z{Score: float64(now + VoteScore), Member: comment})
+			pipe.ZAdd(ctx, "replies:time:"+parent, redis.Z{Score: float64(now), Member: comment})
+
+			// 记录文章下的所有评论，并更新文章的评论数量
+			pipe.SAdd(ctx, "comments:"+articleId, commentId)
+			pipe.HIncrBy(ctx, article, "comments", 1)
+			return nil
+		})
+		return err
+	}, article, parent)
+	if err != nil {
+		logs.Warnw("failed to post comment", "article", article, "parent", parent, "error", err)
+		return "", err
+	}
+
+	return commentId, nil
+}
+
+// checkParentOwner 根据读取被回复评论的article字段的结果，确认评论存在并且属于同一篇文章
+func checkParentOwner(err error, owner, parent, articleId string) error {
+	if errors.Is(err, goredis.Nil) || (err == nil && owner != articleId) {
+		return fmt.Errorf("%w: %s", ErrCommentNotFound, parent)
+	}
+	return err
+}
+
+// CommentVote 为评论投赞成票
+func CommentVote(ctx context.Context, commentId, user string) error {
+	return voteComment(ctx, commentId, user, VoteUp)
+}
+
+// CommentDownvote 为评论投反对票
+func CommentDownvote(ctx context.Context, commentId, user string) error {
+	return voteComment(ctx, commentId, user, VoteDown)
+}
+
+// CommentUnvote 撤销用户对评论的投票
+func CommentUnvote(ctx context.Context, commentId, user string) error {
+	return voteComment(ctx, commentId, user, VoteNone)
+}
+
+func voteComment(ctx context.Context, commentId, user string, direction VoteDirection) error {
+	comment := "comment:" + commentId
+
+	data, err := redis.HGetAll(ctx, comment)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("%w: %s", ErrCommentNotFound, comment)
+	}
+
+	parent := "article:" + data["article"]
+	if data["parent"] != "" {
+		parent = "comment:" + data["parent"]
+	}
+
+	// 评论的评分规则与文章相同，复用文章的投票脚本
+	now := time.Now().Unix()
+	cutoff := now - OneWeekInSeconds
+	keys := []string{"replies:time:" + parent, "replies:score:" + parent, comment,
+		"comment-voted:" + commentId, "comment-downvoted:" + commentId}
+	res, err := evalScript(ctx, voteScript, keys, user, int(direction), cutoff, VoteScore, OneWeekInSeconds, now, 0)
+	if err != nil {
+		return err
+	}
+
+	switch parseVoteResult(res).status {
+	case -1:
+		return fmt.Errorf("%w: %s", ErrCommentNotFound, comment)
+	case 0:
+		logs.Infow("vote time out", "comment", comment, "cutoff", cutoff)
+		return fmt.Errorf("%w: %s", ErrVotingClosed, comment)
+	}
+
+	return nil
+}
+
+// GetComments 分页获取文章或评论的直接回复，并递归加载depth层回复，每层回复只加载第一页
+func GetComments(ctx context.Context, articleId, parentId string, page int64, order string, depth int) ([]*Comment, error) {
+	switch order {
+	case "":
+		order = "score:"
+	case "score:", "time:":
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidOrder, order)
+	}
+
+	parent := "article:" + articleId
+	if parentId != "" {
+		parent = "comment:" + parentId
+	}
+
+	// 设置获取评论的起始索引和结束索引
+	start := (page - 1) * CommentsPrePage
+	end := start + CommentsPrePage - 1
+
+	ids, err := redis.ZRevRange(ctx, "replies:"+order+parent, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := fetchComments(ctx, parent, ids)
+	if depth <= 0 {
+		return comments, err
+	}
+
+	errs := []error{err}
+	for _, comment := range comments {
+		replies, err := GetComments(ctx, articleId, comment.Id, 1, order, depth-1)
+		errs = append(errs, err)
+		comment.Replies = replies
+	}
+
+	return comments, errors.Join(errs...)
+}
+
+// fetchComments 使用一个流水线获取同一父节点下的多条评论及其评分
+func fetchComments(ctx context.Context, parent string, keys []string) ([]*Comment, error) {
+	if len(keys) == 0 {
+		return []*Comment{}, nil
+	}
+
+	pipe := redis.Pipeline()
+	cmds := make([]*goredis.MapStringStringCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, pipe.HGetAll(ctx, key))
+	}
+	scores := pipe.ZMScore(ctx, "replies:score:"+parent, keys...)
+	// 单个命令的错误会在下面逐一检查
+	_, _ = pipe.Exec(ctx)
+
+	if err := scores.Err(); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	comments := make([]*Comment, 0, len(keys))
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("comment %s: %w", keys[i], err))
+			continue
+		}
+
+		comment, err := DecodeComment(keys[i], data)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		comment.Score = scores.Val()[i]
+		comments = append(comments, comment)
+	}
+
+	return comments, errors.Join(errs...)
+}
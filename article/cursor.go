@@ -0,0 +1,130 @@
+package article
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/chaos-io/chaos/redis"
+)
+
+// ErrInvalidCursor 游标无法解析
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ArticlePage 游标分页的结果，Next为空表示没有更多文章
+type ArticlePage struct {
+	Articles []*Article `json:"articles"`
+	Next     string     `json:"next,omitempty"`
+}
+
+// EncodeCursor 将上一页最后一篇文章的评分和成员编码成不透明的游标
+func EncodeCursor(score float64, member string) string {
+	raw := strconv.FormatFloat(score, 'g', -1, 64) + "|" + member
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor 解析EncodeCursor生成的游标
+func DecodeCursor(cursor string) (float64, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+
+	scoreStr, member, ok := strings.Cut(string(raw), "|")
+	if !ok || member == "" {
+		return 0, "", ErrInvalidCursor
+	}
+
+	score, err := strconv.ParseFloat(scoreStr, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+
+	return score, member, nil
+}
+
+// GetArticleByCursor 按order从高到低基于游标分页获取文章，cursor为空表示第一页，size<=0时使用ArticlesPrePage。
+// 游标记录的是评分和成员而不是页码，评分变化时不会出现重复或遗漏的文章。
+func GetArticleByCursor(ctx context.Context, order, cursor string, size int64) (*ArticlePage, error) {
+	order, err := resolveOrder(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		size = ArticlesPrePage
+	}
+
+	entries, err := rangeAfterCursor(ctx, order, cursor, size)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Member.(string))
+	}
+
+	articles, err := fetchListedArticles(ctx, order, keys)
+	page := &ArticlePage{Articles: articles}
+	if int64(len(entries)) == size {
+		last := entries[len(entries)-1]
+		page.Next = EncodeCursor(last.Score, last.Member.(string))
+	}
+
+	return page, err
+}
+
+// GetGroupArticlesByCursor 基于游标分页获取群组中的文章
+func GetGroupArticlesByCursor(ctx context.Context, group, order, cursor string, size int64) (*ArticlePage, error) {
+	key, err := groupOrderKey(ctx, group, order)
+	if err != nil {
+		return nil, err
+	}
+	return GetArticleByCursor(ctx, key, cursor, size)
+}
+
+// rangeAfterCursor 获取排在游标之后的size个成员
+func rangeAfterCursor(ctx context.Context, key, cursor string, size int64) ([]redis.Z, error) {
+	if cursor == "" {
+		return zRevRangeByScore(ctx, key, "+inf", size)
+	}
+
+	score, member, err := DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	max := strconv.FormatFloat(score, 'g', -1, 64)
+
+	// 评分严格小于游标的成员使用排他的上界查询；
+	// 与游标评分相同的成员按成员名倒序排列，只保留排在游标成员之后的部分
+	pipe := redis.Pipeline()
+	ties := pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Max: max, Min: max})
+	lower := pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Max: "(" + max, Min: "-inf", Count: size})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	entries := make([]redis.Z, 0, size)
+	for _, z := range ties.Val() {
+		if z.Member.(string) < member {
+			entries = append(entries, z)
+		}
+	}
+	entries = append(entries, lower.Val()...)
+	if int64(len(entries)) > size {
+		entries = entries[:size]
+	}
+
+	return entries, nil
+}
+
+func zRevRangeByScore(ctx context.Context, key, max string, size int64) ([]redis.Z, error) {
+	pipe := redis.Pipeline()
+	cmd := pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Max: max, Min: "-inf", Count: size})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return cmd.Val(), nil
+}
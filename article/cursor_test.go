@@ -0,0 +1,21 @@
+package article
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := EncodeCursor(1700000432.5, "article:12")
+
+	score, member, err := DecodeCursor(cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, 1700000432.5, score)
+	assert.Equal(t, "article:12", member)
+
+	_, _, err = DecodeCursor("not a cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, _, err = DecodeCursor(EncodeCursor(1, ""))
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -0,0 +1,162 @@
+package article
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+)
+
+// EventType 文章事件的类型
+type EventType string
+
+const (
+	EventPosted     EventType = "posted"
+	EventGroupAdded EventType = "group_added"
+	EventMilestone  EventType = "milestone"
+)
+
+// EventsChannel 所有文章事件都会发布到这个频道，群组相关的事件还会发布到group-events:<group>
+const EventsChannel = "article-events:"
+
+// VoteMilestones 文章的赞成票数量达到这些值时发布EventMilestone事件
+var VoteMilestones = []int64{10, 50, 100, 500, 1000}
+
+// Event 发布到频道中的文章事件
+type Event struct {
+	Type    EventType `json:"type"`
+	Article string    `json:"article"`
+	Title   string    `json:"title,omitempty"`
+	Poster  string    `json:"poster,omitempty"`
+	Group   string    `json:"group,omitempty"`
+	Votes   int64     `json:"votes,omitempty"`
+	Time    int64     `json:"time"`
+}
+
+// GroupChannel 返回群组事件的频道
+func GroupChannel(group string) string {
+	return "group-events:" + group
+}
+
+// publishEvent 将事件发布到channels，发布失败只记录日志，不影响文章操作本身
+func publishEvent(ctx context.Context, event Event, channels ...string) {
+	event.Time = time.Now().Unix()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logs.Warnw("failed to marshal event", "event", event, "error", err)
+		return
+	}
+
+	pipe := redis.Pipeline()
+	for _, channel := range channels {
+		pipe.Publish(ctx, channel, payload)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.Warnw("failed to publish event", "event", event, "error", err)
+	}
+}
+
+// publishMilestone 文章的赞成票数量刚好达到里程碑时发布事件，每个里程碑只发布一次，votes为投票脚本返回的票数
+func publishMilestone(ctx context.Context, articleId string, votes int64) {
+	if !slices.Contains(VoteMilestones, votes) {
+		return
+	}
+
+	// 撤票后重新投票可能再次达到同一个里程碑，用集合去重
+	article := "article:" + articleId
+	if added, err := redis.SAdd(ctx, "milestones:"+articleId, votes); err != nil || added == 0 {
+		return
+	}
+
+	pipe := redis.Pipeline()
+	data := pipe.HGetAll(ctx, article)
+	groups := pipe.SMembers(ctx, "groups:"+articleId)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logs.Warnw("failed to publish vote milestone", "article", article, "error", err)
+		return
+	}
+
+	decoded, err := DecodeArticle(article, data.Val())
+	if err != nil {
+		return
+	}
+
+	channels := []string{EventsChannel}
+	for _, group := range groups.Val() {
+		channels = append(channels, GroupChannel(group))
+	}
+	publishEvent(ctx, Event{
+		Type:    EventMilestone,
+		Article: articleId,
+		Title:   decoded.Title,
+		Poster:  decoded.Poster,
+		Votes:   votes,
+	}, channels...)
+}
+
+// Subscription 文章事件的订阅
+type Subscription struct {
+	pubSub *redis.PubSub
+	events chan *Event
+	// done 在Close时关闭，让阻塞在发送事件上的接收协程退出
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Subscribe 订阅群组的文章事件，不指定群组时订阅所有文章事件。
+// 返回时订阅已经生效，调用方需要在不再需要时调用Close。
+func Subscribe(ctx context.Context, groups ...string) (*Subscription, error) {
+	channels := []string{EventsChannel}
+	if len(groups) > 0 {
+		channels = make([]string, 0, len(groups))
+		for _, group := range groups {
+			channels = append(channels, GroupChannel(group))
+		}
+	}
+
+	pubSub := redis.Subscribe(ctx, channels...)
+	// 等待订阅确认，避免漏掉订阅生效前发布的事件
+	if _, err := pubSub.Receive(ctx); err != nil {
+		_ = pubSub.Close()
+		return nil, err
+	}
+
+	sub := &Subscription{pubSub: pubSub, events: make(chan *Event), done: make(chan struct{})}
+	go sub.receive(ctx)
+	return sub, nil
+}
+
+// Events 返回接收事件的通道，订阅关闭后通道会被关闭
+func (s *Subscription) Events() <-chan *Event {
+	return s.events
+}
+
+// Close 取消订阅，可以重复调用
+func (s *Subscription) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return s.pubSub.Close()
+}
+
+func (s *Subscription) receive(ctx context.Context) {
+	defer close(s.events)
+
+	for msg := range s.pubSub.Channel() {
+		event := &Event{}
+		if err := json.Unmarshal([]byte(msg.Payload), event); err != nil {
+			logs.Warnw("failed to unmarshal event", "channel", msg.Channel, "error", err)
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		case <-s.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
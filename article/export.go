@@ -0,0 +1,289 @@
+package article
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// ExportRecord 导出文件中的一行，对应一篇文章
+type ExportRecord struct {
+	Key    string            `json:"key"`
+	Fields map[string]string `json:"fields"`
+	Score  float64           `json:"score"`
+	Time   float64           `json:"time"`
+	Groups []string          `json:"groups,omitempty"`
+	// Voters 为true表示导出时包含投票名单，名单为空也表示文章没有投票
+	Voters     bool     `json:"voters,omitempty"`
+	Upvoters   []string `json:"upvoters,omitempty"`
+	Downvoters []string `json:"downvoters,omitempty"`
+}
+
+// maxLineSize 导入时单行的最大长度，投票名单较大的文章一行可能很长
+const maxLineSize = 64 << 20
+
+// setCounterScript 只在计数器小于ARGV[1]时更新，避免导入旧数据后生成重复的文章Id
+const setCounterScript = `
+local current = tonumber(redis.call("get", KEYS[1]) or 0)
+if current < tonumber(ARGV[1]) then
+    redis.call("set", KEYS[1], ARGV[1])
+    return tonumber(ARGV[1])
+end
+return current
+`
+
+// ExportArticles 按发布时间从旧到新将所有文章以JSON行的形式写入w，withVoters为true时同时导出投票名单，
+// 返回导出的文章数量
+func ExportArticles(ctx context.Context, w io.Writer, withVoters bool) (int, error) {
+	const batch = 100
+
+	count := 0
+	encoder := json.NewEncoder(w)
+	for start := int64(0); ; start += batch {
+		entries, err := redis.ZRangeWithScores(ctx, "time:", start, start+batch-1)
+		if err != nil {
+			return count, err
+		}
+		if len(entries) == 0 {
+			return count, nil
+		}
+
+		records, err := fetchExportRecords(ctx, entries, withVoters)
+		if err != nil {
+			return count, err
+		}
+
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+}
+
+func fetchExportRecords(ctx context.Context, entries []redis.Z, withVoters bool) ([]*ExportRecord, error) {
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Member.(string))
+	}
+
+	pipe := redis.Pipeline()
+	scores := pipe.ZMScore(ctx, "score:", keys...)
+	hashes := make([]*goredis.MapStringStringCmd, 0, len(keys))
+	groups := make([]*goredis.StringSliceCmd, 0, len(keys))
+	upvoters := make([]*goredis.StringSliceCmd, 0, len(keys))
+	downvoters := make([]*goredis.StringSliceCmd, 0, len(keys))
+	for _, key := range keys {
+		articleId := strings.TrimPrefix(key, "article:")
+		hashes = append(hashes, pipe.HGetAll(ctx, key))
+		groups = append(groups, pipe.SMembers(ctx, "groups:"+articleId))
+		if withVoters {
+			upvoters = append(upvoters, pipe.SMembers(ctx, "voted:"+articleId))
+			downvoters = append(downvoters, pipe.SMembers(ctx, "downvoted:"+articleId))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	records := make([]*ExportRecord, 0, len(keys))
+	for i, key := range keys {
+		fields, _ := hashes[i].Result()
+		// 遍历过程中被删除的文章
+		if len(fields) == 0 {
+			continue
+		}
+
+		record := &ExportRecord{
+			Key:    key,
+			Fields: fields,
+			Score:  scores.Val()[i],
+			Time:   entries[i].Score,
+			Groups: groups[i].Val(),
+		}
+		if withVoters {
+			record.Voters = true
+			record.Upvoters = upvoters[i].Val()
+			record.Downvoters = downvoters[i].Val()
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// ImportArticles 从r中读取ExportArticles导出的JSON行并恢复文章，重复导入同一份数据的结果相同。
+// 投票名单只在文章仍处于投票期并且导出时包含投票名单时恢复，否则保留已有的投票名单，导入完成后文章Id计数器不会小于导入的最大Id。
+// 返回导入的文章数量。
+func ImportArticles(ctx context.Context, r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	count := 0
+	var maxId int64
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		record := &ExportRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return count, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		articleId, ok := strings.CutPrefix(record.Key, "article:")
+		id, err := strconv.ParseInt(articleId, 10, 64)
+		if !ok || err != nil {
+			return count, fmt.Errorf("line %d: %w: %s", line, ErrInvalidArticle, record.Key)
+		}
+		if len(record.Fields) == 0 {
+			return count, fmt.Errorf("line %d: article %s has no fields", line, record.Key)
+		}
+
+		if err := importArticle(ctx, articleId, record); err != nil {
+			return count, fmt.Errorf("line %d: %w", line, err)
+		}
+		maxId = max(maxId, id)
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+
+	if _, err := evalScript(ctx, setCounterScript, []string{"article:"}, maxId); err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
+
+func importArticle(ctx context.Context, articleId string, record *ExportRecord) error {
+	article := record.Key
+	groupsKey := "groups:" + articleId
+	voted, downvoted := "voted:"+articleId, "downvoted:"+articleId
+
+	var changed []string
+	err := watchWithRetry(ctx, func(tx *redis.Tx) error {
+		changed = changed[:0]
+		// 读取已有的数据，覆盖时清理旧的全文索引和群组
+		old, err := tx.HGetAll(ctx, article).Result()
+		if err != nil {
+			return err
+		}
+		oldGroups, err := tx.SMembers(ctx, groupsKey).Result()
+		if err != nil {
+			return err
+		}
+		var oldUpvoters, oldDownvoters []string
+		if record.Voters {
+			if oldUpvoters, err = tx.SMembers(ctx, voted).Result(); err != nil {
+				return err
+			}
+			if oldDownvoters, err = tx.SMembers(ctx, downvoted).Result(); err != nil {
+				return err
+			}
+		}
+
+		changed = append(changed, record.Groups...)
+		for _, group := range oldGroups {
+			if !slices.Contains(record.Groups, group) {
+				changed = append(changed, group)
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			fields := make([]interface{}, 0, len(record.Fields)*2)
+			for field, value := range record.Fields {
+				fields = append(fields, field, value)
+			}
+			pipe.Del(ctx, article)
+			pipe.HSet(ctx, article, fields...)
+			pipe.ZAdd(ctx, "score:", redis.Z{Score: record.Score, Member: article})
+			pipe.ZAdd(ctx, "time:", redis.Z{Score: record.Time, Member: article})
+			if record.Fields["archived"] == "1" {
+				pipe.ZAdd(ctx, "archived:", redis.Z{Score: record.Time, Member: article})
+			}
+			indexTitle(ctx, pipe, article, old["title"], record.Fields["title"])
+			if poster := record.Fields["poster"]; poster != "" {
+				pipe.ZAdd(ctx, "posted:"+poster, redis.Z{Score: record.Time, Member: article})
+			}
+
+			// 覆盖时先扣除旧文章为发布者带来的karma，再计入导入的文章带来的karma
+			if karma := earnedKarma(old); len(old) > 0 && karma != 0 && old["poster"] != "" {
+				pipe.ZIncrBy(ctx, "karma:", -karma, old["poster"])
+			}
+			if karma := earnedKarma(record.Fields); karma != 0 && record.Fields["poster"] != "" {
+				pipe.ZIncrBy(ctx, "karma:", karma, record.Fields["poster"])
+			}
+
+			for _, group := range oldGroups {
+				pipe.SRem(ctx, "group:"+group, article)
+			}
+			pipe.Del(ctx, groupsKey)
+			for _, group := range record.Groups {
+				pipe.SAdd(ctx, "group:"+group, article)
+				pipe.SAdd(ctx, groupsKey, group)
+				pipe.SAdd(ctx, "groups:", group)
+			}
+
+			// 导出时没有包含投票名单，保留已有的投票名单和用户的投票索引
+			if !record.Voters {
+				return nil
+			}
+
+			// 替换投票名单，并清理不在新名单中的用户的投票索引。投票期已结束的文章不再需要投票名单
+			for _, user := range oldUpvoters {
+				if !slices.Contains(record.Upvoters, user) {
+					pipe.ZRem(ctx, "user-voted:"+user, article)
+				}
+			}
+			for _, user := range oldDownvoters {
+				if !slices.Contains(record.Downvoters, user) {
+					pipe.ZRem(ctx, "user-downvoted:"+user, article)
+				}
+			}
+			pipe.Del(ctx, voted, downvoted)
+			expireAt := time.Unix(int64(record.Time)+OneWeekInSeconds, 0)
+			if time.Now().Before(expireAt) {
+				for key, users := range map[string][]string{voted: record.Upvoters, downvoted: record.Downvoters} {
+					if len(users) > 0 {
+						pipe.SAdd(ctx, key, toInterfaces(users)...)
+						pipe.ExpireAt(ctx, key, expireAt)
+					}
+				}
+
+				// 投票时间没有导出，使用文章的发布时间恢复用户的投票索引
+				for _, user := range record.Upvoters {
+					pipe.ZAdd(ctx, "user-voted:"+user, redis.Z{Score: record.Time, Member: article})
+				}
+				for _, user := range record.Downvoters {
+					pipe.ZAdd(ctx, "user-downvoted:"+user, redis.Z{Score: record.Time, Member: article})
+				}
+			}
+			return nil
+		})
+		return err
+	}, article, groupsKey, voted, downvoted)
+	if err != nil {
+		return err
+	}
+
+	// 已冻结的文章恢复归档记录，其他文章可能早于归档的进度，需要调低进度以便之后冻结
+	if record.Fields["archived"] != "1" {
+		if err := lowerArchiveWatermark(ctx, int64(record.Time)); err != nil {
+			return err
+		}
+	}
+
+	return invalidateGroups(ctx, changed...)
+}
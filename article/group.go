@@ -0,0 +1,247 @@
+package article
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrGroupNotFound 群组不存在
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupExists 群组已存在
+	ErrGroupExists = errors.New("group already exists")
+)
+
+// Group 群组的元数据，保存在散列group-info:<name>中，所有群组的名称保存在集合groups:中。
+// 引入groups:之前隐式创建的群组由IndexGroups回填，回填之前只要group:<name>中有文章也视为群组存在。
+type Group struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Creator     string `json:"creator,omitempty"`
+	Created     int64  `json:"created,omitempty"`
+	Articles    int64  `json:"articles"`
+}
+
+// CreateGroup 创建群组并记录元数据。通过AddRemoveGroups隐式创建的群组没有元数据，也可以用CreateGroup补充。
+func CreateGroup(ctx context.Context, name, creator, description string) error {
+	if name == "" {
+		return errors.New("group name is required")
+	}
+	info := "group-info:" + name
+
+	return watchWithRetry(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, info).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return fmt.Errorf("%w: %s", ErrGroupExists, name)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, info, "description", description, "creator", creator, "created", time.Now().Unix())
+			pipe.SAdd(ctx, "groups:", name)
+			return nil
+		})
+		return err
+	}, info)
+}
+
+// GetGroup 获取群组的元数据和文章数量
+func GetGroup(ctx context.Context, name string) (*Group, error) {
+	groups, err := fetchGroups(ctx, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	return groups[0], nil
+}
+
+// ListGroups 按名称排序列出所有群组及其文章数量
+func ListGroups(ctx context.Context) ([]*Group, error) {
+	if err := ensureGroupsIndexed(ctx); err != nil {
+		return nil, err
+	}
+
+	pipe := redis.Pipeline()
+	cmd := pipe.SMembers(ctx, "groups:")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	names := cmd.Val()
+	sort.Strings(names)
+	return fetchGroups(ctx, names)
+}
+
+func fetchGroups(ctx context.Context, names []string) ([]*Group, error) {
+	if len(names) == 0 {
+		return []*Group{}, nil
+	}
+
+	pipe := redis.Pipeline()
+	registered := pipe.SMIsMember(ctx, "groups:", toInterfaces(names)...)
+	infos := make([]*goredis.MapStringStringCmd, 0, len(names))
+	counts := make([]*goredis.IntCmd, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, pipe.HGetAll(ctx, "group-info:"+name))
+		counts = append(counts, pipe.SCard(ctx, "group:"+name))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	groups := make([]*Group, 0, len(names))
+	for i, name := range names {
+		if !registered.Val()[i] && counts[i].Val() == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+		}
+
+		info, _ := infos[i].Result()
+		created, err := parseInt(info, "created")
+		if err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+		groups = append(groups, &Group{
+			Name:        name,
+			Description: info["description"],
+			Creator:     info["creator"],
+			Created:     created,
+			Articles:    counts[i].Val(),
+		})
+	}
+
+	return groups, nil
+}
+
+// GetArticleGroups 按名称排序列出文章所属的群组
+func GetArticleGroups(ctx context.Context, articleId string) ([]string, error) {
+	pipe := redis.Pipeline()
+	cmd := pipe.SMembers(ctx, "groups:"+articleId)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	groups := cmd.Val()
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// RenameGroup 重命名群组，同时迁移群组的文章、元数据以及文章所属群组的记录
+func RenameGroup(ctx context.Context, name, newName string) error {
+	if newName == "" {
+		return errors.New("group name is required")
+	}
+
+	group, newGroup := "group:"+name, "group:"+newName
+	info, newInfo := "group-info:"+name, "group-info:"+newName
+
+	err := watchWithRetry(ctx, func(tx *redis.Tx) error {
+		exists, err := groupsExist(ctx, tx, name, newName)
+		if err != nil {
+			return err
+		}
+		if !exists[0] {
+			return fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+		}
+		if exists[1] {
+			return fmt.Errorf("%w: %s", ErrGroupExists, newName)
+		}
+
+		articles, err := tx.SMembers(ctx, group).Result()
+		if err != nil {
+			return err
+		}
+		hasInfo, err := tx.Exists(ctx, info).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(articles) > 0 {
+				pipe.Rename(ctx, group, newGroup)
+			}
+			if hasInfo > 0 {
+				pipe.Rename(ctx, info, newInfo)
+			}
+			pipe.SRem(ctx, "groups:", name)
+			pipe.SAdd(ctx, "groups:", newName)
+			for _, article := range articles {
+				articleId := strings.TrimPrefix(article, "article:")
+				pipe.SRem(ctx, "groups:"+articleId, name)
+				pipe.SAdd(ctx, "groups:"+articleId, newName)
+			}
+			return nil
+		})
+		return err
+	}, "groups:", group, newGroup, info)
+	if err != nil {
+		logs.Warnw("failed to rename group", "group", name, "newName", newName, "error", err)
+		return err
+	}
+
+	return invalidateGroups(ctx, name, newName)
+}
+
+// DeleteGroup 删除群组及其元数据，文章本身不会被删除
+func DeleteGroup(ctx context.Context, name string) error {
+	group := "group:" + name
+
+	err := watchWithRetry(ctx, func(tx *redis.Tx) error {
+		exists, err := groupsExist(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		if !exists[0] {
+			return fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+		}
+
+		articles, err := tx.SMembers(ctx, group).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, group, "group-info:"+name)
+			pipe.SRem(ctx, "groups:", name)
+			for _, article := range articles {
+				pipe.SRem(ctx, "groups:"+strings.TrimPrefix(article, "article:"), name)
+			}
+			return nil
+		})
+		return err
+	}, "groups:", group)
+	if err != nil {
+		logs.Warnw("failed to delete group", "group", name, "error", err)
+		return err
+	}
+
+	return invalidateGroups(ctx, name)
+}
+
+// groupsExist 判断群组是否存在：已登记在groups:中，或者是还没有回填的隐式创建的群组，group:<name>中有文章
+func groupsExist(ctx context.Context, tx *redis.Tx, names ...string) ([]bool, error) {
+	exists, err := tx.SMIsMember(ctx, "groups:", toInterfaces(names)...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range names {
+		if exists[i] {
+			continue
+		}
+		count, err := tx.SCard(ctx, "group:"+name).Result()
+		if err != nil {
+			return nil, err
+		}
+		exists[i] = count > 0
+	}
+	return exists, nil
+}
@@ -0,0 +1,41 @@
+package article
+
+import (
+	"context"
+
+	"github.com/chaos-io/chaos/redis"
+)
+
+// KARMAWEIGHTED 为true时，投票计入评分的权重为 1 + log10(1 + 投票者的karma)，
+// karma越高的用户投票对评分的影响越大
+var KARMAWEIGHTED = false
+
+// UserKarma 用户及其karma，karma为用户发布的文章获得的净票数（不含自己的投票）
+type UserKarma struct {
+	User  string `json:"user"`
+	Karma int64  `json:"karma"`
+}
+
+// GetKarma 获取用户的karma，没有karma记录的用户返回0
+func GetKarma(ctx context.Context, user string) (int64, error) {
+	scores, err := zmScore(ctx, "karma:", []string{user})
+	if err != nil {
+		return 0, err
+	}
+	return int64(scores[0]), nil
+}
+
+// TopUsers 获取karma排行榜的前n名用户
+func TopUsers(ctx context.Context, n int64) ([]UserKarma, error) {
+	pipe := redis.Pipeline()
+	cmd := pipe.ZRevRangeWithScores(ctx, "karma:", 0, n-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	users := make([]UserKarma, 0, len(cmd.Val()))
+	for _, z := range cmd.Val() {
+		users = append(users, UserKarma{User: z.Member.(string), Karma: int64(z.Score)})
+	}
+	return users, nil
+}
@@ -0,0 +1,237 @@
+package article
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// watchRetries 被监视的键在事务提交前被修改时的最大重试次数
+const watchRetries = 5
+
+// watchWithRetry 执行乐观锁事务，被监视的键在提交前被修改（例如文章收到投票）时重新执行fn，
+// 重试次数用完后返回TxFailedErr
+func watchWithRetry(ctx context.Context, fn func(*redis.Tx) error, keys ...string) error {
+	var err error
+	for i := 0; i < watchRetries; i++ {
+		if err = redis.Watch(ctx, fn, keys...); !errors.Is(err, goredis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+// UpdateArticle 修改文章的标题和链接，参数为空表示不修改该字段
+func UpdateArticle(ctx context.Context, articleId, title, link string) error {
+	article := "article:" + articleId
+
+	fields := make([]interface{}, 0, 4)
+	if title != "" {
+		fields = append(fields, "title", title)
+	}
+	if link != "" {
+		fields = append(fields, "link", link)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// 监视文章散列，避免修改的同时文章被删除而留下残缺的散列
+	err := watchWithRetry(ctx, func(tx *redis.Tx) error {
+		data, err := tx.HGetAll(ctx, article).Result()
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("%w: %s", ErrArticleNotFound, article)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, article, fields...)
+			if title != "" {
+				indexTitle(ctx, pipe, article, data["title"], title)
+			}
+			return nil
+		})
+		return err
+	}, article)
+	if err != nil {
+		logs.Warnw("failed to update article", "article", article, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteArticle 删除文章以及它在评分、时间、投票、热门列表、群组、评论和全文索引中的所有索引，
+// 并扣除文章为发布者带来的karma
+func DeleteArticle(ctx context.Context, articleId string) error {
+	article := "article:" + articleId
+	groupsKey := "groups:" + articleId
+	commentsKey := "comments:" + articleId
+
+	// 在引入groups:<id>之前加入群组的文章没有反向索引，先回填
+	if err := ensureGroupsIndexed(ctx); err != nil {
+		return err
+	}
+
+	var groups []string
+	err := watchWithRetry(ctx, func(tx *redis.Tx) error {
+		data, err := tx.HGetAll(ctx, article).Result()
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("%w: %s", ErrArticleNotFound, article)
+		}
+
+		// 投票期内的投票名单仍然存在，据此清理投票用户的索引
+		upvoters, err := tx.SMembers(ctx, "voted:"+articleId).Result()
+		if err != nil {
+			return err
+		}
+		downvoters, err := tx.SMembers(ctx, "downvoted:"+articleId).Result()
+		if err != nil {
+			return err
+		}
+
+		groups, err = tx.SMembers(ctx, groupsKey).Result()
+		if err != nil {
+			return err
+		}
+
+		comments, err := tx.SMembers(ctx, commentsKey).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, article, "voted:"+articleId, "downvoted:"+articleId, "flagged-votes:"+articleId,
+				"vote-weights:"+articleId, "related:"+articleId, "milestones:"+articleId, groupsKey)
+			pipe.ZRem(ctx, "score:", article)
+			pipe.ZRem(ctx, "time:", article)
+			pipe.ZRem(ctx, "archived:", article)
+			removeFromTrending(ctx, pipe, article, time.Now().Unix())
+
+			// 从全文索引、发布者和投票用户的索引中移除文章
+			indexTitle(ctx, pipe, article, data["title"], "")
+			pipe.ZRem(ctx, "posted:"+data["poster"], article)
+			if karma := earnedKarma(data); karma != 0 && data["poster"] != "" {
+				pipe.ZIncrBy(ctx, "karma:", -karma, data["poster"])
+			}
+			for _, user := range upvoters {
+				pipe.ZRem(ctx, "user-voted:"+user, article)
+			}
+			for _, user := range downvoters {
+				pipe.ZRem(ctx, "user-downvoted:"+user, article)
+			}
+
+			// 从所有群组中移除文章
+			for _, group := range groups {
+				pipe.SRem(ctx, "group:"+group, article)
+			}
+
+			// 删除文章下的所有评论及其投票名单和回复索引
+			pipe.Del(ctx, commentsKey, "replies:score:"+article, "replies:time:"+article)
+			for _, commentId := range comments {
+				comment := "comment:" + commentId
+				pipe.Del(ctx, comment, "comment-voted:"+commentId, "comment-downvoted:"+commentId,
+					"replies:score:"+comment, "replies:time:"+comment)
+			}
+			return nil
+		})
+		return err
+	}, article, "voted:"+articleId, "downvoted:"+articleId, groupsKey, commentsKey)
+	if err != nil {
+		logs.Warnw("failed to delete article", "article", article, "error", err)
+		return err
+	}
+
+	// 让缓存的群组排序结果和查询结果失效
+	return invalidateGroups(ctx, groups...)
+}
+
+// earnedKarma 返回文章为发布者带来的karma，没有记录karma的旧文章按除发布者以外的净票数估算
+func earnedKarma(data map[string]string) float64 {
+	if value, ok := data["karma"]; ok {
+		karma, _ := strconv.ParseFloat(value, 64)
+		return karma
+	}
+
+	votes, _ := strconv.ParseInt(data["votes"], 10, 64)
+	downvotes, _ := strconv.ParseInt(data["downvotes"], 10, 64)
+	return float64(votes - downvotes - 1)
+}
+
+// ensureGroupsIndexed 反向索引和群组名单还没有回填过时执行一次IndexGroups
+func ensureGroupsIndexed(ctx context.Context) error {
+	indexed, err := redis.Exists(ctx, "groups-indexed:")
+	if err != nil || indexed {
+		return err
+	}
+	return IndexGroups(ctx)
+}
+
+// IndexGroups 遍历所有group:<name>集合，回填文章所属群组的反向索引groups:<id>以及群组名单groups:。
+// 重复执行没有副作用，执行完成后记录在groups-indexed:中。
+func IndexGroups(ctx context.Context) error {
+	keys, err := scanKeys(ctx, "group:*")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		group := strings.TrimPrefix(key, "group:")
+		pipe := redis.Pipeline()
+		cmd := pipe.SMembers(ctx, key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		pipe = redis.Pipeline()
+		for _, article := range cmd.Val() {
+			pipe.SAdd(ctx, "groups:"+strings.TrimPrefix(article, "article:"), group)
+		}
+		pipe.SAdd(ctx, "groups:", group)
+		if len(cmd.Val()) > 0 {
+			if _, err := pipe.Exec(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = redis.Set(ctx, "groups-indexed:", time.Now().Unix(), 0)
+	return err
+}
+
+// scanKeys 使用SCAN遍历匹配pattern的所有键，不会像KEYS一样阻塞服务器
+func scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	keys := make([]string, 0)
+	cursor := "0"
+	for {
+		res, err := redis.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", 1000)
+		if err != nil {
+			return keys, err
+		}
+
+		reply, ok := res.([]interface{})
+		if !ok || len(reply) != 2 {
+			return keys, fmt.Errorf("unexpected scan reply %v", res)
+		}
+		cursor, _ = reply[0].(string)
+		batch, _ := reply[1].([]interface{})
+		for _, key := range batch {
+			keys = append(keys, key.(string))
+		}
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package article
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// ErrArticleNotFound 文章散列不存在
+var ErrArticleNotFound = errors.New("article not found")
+
+// Article 文章散列article:<id>的结构化表示，Score来自有序集合score:
+type Article struct {
+	Id        string  `json:"id"`
+	Title     string  `json:"title"`
+	Link      string  `json:"link"`
+	Poster    string  `json:"poster"`
+	Time      int64   `json:"time"`
+	Votes     int64   `json:"votes"`
+	Downvotes int64   `json:"downvotes"`
+	Comments  int64   `json:"comments"`
+	Score     float64 `json:"score"`
+	Archived  bool    `json:"archived,omitempty"`
+
+	// weighted 按投票者karma加权后的净票数，旧文章没有记录时hasWeighted为false
+	weighted    float64
+	hasWeighted bool
+}
+
+// NetVotes 返回文章的净票数，开启KARMAWEIGHTED之后记录的是加权后的净票数，
+// 没有加权记录时使用赞成票减反对票
+func (a *Article) NetVotes() float64 {
+	if a.hasWeighted {
+		return a.weighted
+	}
+	return float64(a.Votes - a.Downvotes)
+}
+
+// Key 返回文章散列的键
+func (a *Article) Key() string {
+	return "article:" + a.Id
+}
+
+// DecodeArticle 将文章散列解码成Article，key为article:<id>
+func DecodeArticle(key string, data map[string]string) (*Article, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrArticleNotFound, key)
+	}
+
+	article := &Article{
+		Id:       strings.TrimPrefix(key, "article:"),
+		Title:    data["title"],
+		Link:     data["link"],
+		Poster:   data["poster"],
+		Archived: data["archived"] == "1",
+	}
+
+	var err error
+	if article.Time, err = parseInt(data, "time"); err != nil {
+		return nil, fmt.Errorf("article %s: %w", key, err)
+	}
+	if article.Votes, err = parseInt(data, "votes"); err != nil {
+		return nil, fmt.Errorf("article %s: %w", key, err)
+	}
+	if article.Downvotes, err = parseInt(data, "downvotes"); err != nil {
+		return nil, fmt.Errorf("article %s: %w", key, err)
+	}
+	if article.Comments, err = parseInt(data, "comments"); err != nil {
+		return nil, fmt.Errorf("article %s: %w", key, err)
+	}
+	if value, ok := data["weighted"]; ok && value != "" {
+		if article.weighted, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("article %s: invalid weighted %q: %w", key, value, err)
+		}
+		article.hasWeighted = true
+	}
+
+	return article, nil
+}
+
+func parseInt(data map[string]string, field string) (int64, error) {
+	value, ok := data[field]
+	if !ok || value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", field, value, err)
+	}
+	return n, nil
+}
+
+// FetchArticles 使用一个流水线获取多篇文章及其评分，keys为article:<id>。
+// 部分文章获取或解码失败时，返回成功获取的文章以及合并后的错误。
+func FetchArticles(ctx context.Context, keys []string) ([]*Article, error) {
+	articles, _, err := fetchArticles(ctx, keys, false)
+	return articles, err
+}
+
+// fetchListedArticles 获取有序集合index中列出的文章。index中残留的已被删除或移动到冷存储的文章
+// 会被跳过并从index中删除，不会让整页列表失败。游标按评分和成员定位，删除成员后游标仍然有效。
+func fetchListedArticles(ctx context.Context, index string, keys []string) ([]*Article, error) {
+	articles, missing, err := fetchArticles(ctx, keys, true)
+	if len(missing) == 0 {
+		return articles, err
+	}
+
+	logs.Warnw("removed missing articles from index", "index", index, "articles", missing)
+	if _, zremErr := redis.ZRem(ctx, index, toInterfaces(missing)...); zremErr != nil {
+		logs.Warnw("failed to remove missing articles from index", "index", index, "error", zremErr)
+	}
+
+	return articles, err
+}
+
+// fetchArticles skipMissing为true时跳过不存在的文章并返回它们的键，否则作为ErrArticleNotFound返回
+func fetchArticles(ctx context.Context, keys []string, skipMissing bool) ([]*Article, []string, error) {
+	if len(keys) == 0 {
+		return []*Article{}, nil, nil
+	}
+
+	pipe := redis.Pipeline()
+	cmds := make([]*goredis.MapStringStringCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, pipe.HGetAll(ctx, key))
+	}
+	scores := pipe.ZMScore(ctx, "score:", keys...)
+	// 单个命令的错误会在下面逐一检查
+	_, _ = pipe.Exec(ctx)
+
+	if err := scores.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	var missing []string
+	articles := make([]*Article, 0, len(keys))
+	for i, cmd := range cmds {
+		data, err := cmd.Result()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("article %s: %w", keys[i], err))
+			continue
+		}
+
+		if len(data) == 0 && skipMissing {
+			missing = append(missing, keys[i])
+			continue
+		}
+
+		article, err := DecodeArticle(keys[i], data)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		article.Score = scores.Val()[i]
+		articles = append(articles, article)
+	}
+
+	return articles, missing, errors.Join(errs...)
+}
@@ -0,0 +1,29 @@
+package article
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeArticle(t *testing.T) {
+	article, err := DecodeArticle("article:1", map[string]string{
+		"title":  "a title",
+		"link":   "https://g.cn",
+		"poster": "username",
+		"time":   "1700000000",
+		"votes":  "3",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", article.Id)
+	assert.Equal(t, "article:1", article.Key())
+	assert.Equal(t, int64(1700000000), article.Time)
+	assert.Equal(t, int64(3), article.Votes)
+	assert.Equal(t, int64(0), article.Downvotes)
+
+	_, err = DecodeArticle("article:2", map[string]string{})
+	assert.Error(t, err)
+
+	_, err = DecodeArticle("article:3", map[string]string{"votes": "x"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+package article
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// QueryCacheSeconds 群组查询结果的缓存时间，群组成员变化时缓存会被立即删除
+const QueryCacheSeconds = 60
+
+// GroupQuery 多群组查询：文章必须属于All中的所有群组、属于Any中的任意一个群组，并且不属于None中的任何群组。
+// 例如"属于A或B但不属于C"表示为 GroupQuery{Any: []string{"A", "B"}, None: []string{"C"}}。
+type GroupQuery struct {
+	All  []string `json:"all,omitempty"`
+	Any  []string `json:"any,omitempty"`
+	None []string `json:"none,omitempty"`
+}
+
+// Groups 返回查询涉及的所有群组
+func (q GroupQuery) Groups() []string {
+	groups := make([]string, 0, len(q.All)+len(q.Any)+len(q.None))
+	groups = append(groups, q.All...)
+	groups = append(groups, q.Any...)
+	groups = append(groups, q.None...)
+	return groups
+}
+
+// cacheKey 为查询生成确定的缓存键，群组的顺序不影响缓存键
+func (q GroupQuery) cacheKey(order string) string {
+	join := func(groups []string) string {
+		sorted := append([]string(nil), groups...)
+		sort.Strings(sorted)
+		return strings.Join(sorted, ",")
+	}
+	return "query:" + order + "all=" + join(q.All) + "|any=" + join(q.Any) + "|none=" + join(q.None)
+}
+
+// queryScript 在同一个原子操作里计算多群组查询的结果
+// KEYS: dest, order, All群组..., Any群组..., None群组...
+// ARGV: len(All), len(Any), len(None), 缓存时间
+// 返回值: 结果中的文章数量
+const queryScript = `
+local dest, order = KEYS[1], KEYS[2]
+local nAll, nAny, nNone = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local i = 3
+
+-- 交集里只有order贡献分值，群组的权重为0
+local keys, weights = {order}, {1}
+for _ = 1, nAll do
+    table.insert(keys, KEYS[i])
+    table.insert(weights, 0)
+    i = i + 1
+end
+
+local anyKey = dest .. ":any"
+if nAny > 0 then
+    local any = {"zunionstore", anyKey, nAny}
+    for _ = 1, nAny do
+        table.insert(any, KEYS[i])
+        i = i + 1
+    end
+    redis.call(unpack(any))
+    table.insert(keys, anyKey)
+    table.insert(weights, 0)
+end
+
+local inter = {"zinterstore", dest, #keys}
+for _, key in ipairs(keys) do
+    table.insert(inter, key)
+end
+table.insert(inter, "weights")
+for _, weight in ipairs(weights) do
+    table.insert(inter, weight)
+end
+redis.call(unpack(inter))
+redis.call("del", anyKey)
+
+if nNone > 0 then
+    local diff = {"zdiffstore", dest, nNone + 1, dest}
+    for _ = 1, nNone do
+        table.insert(diff, KEYS[i])
+        i = i + 1
+    end
+    redis.call(unpack(diff))
+end
+
+redis.call("expire", dest, ARGV[4])
+return redis.call("zcard", dest)
+`
+
+// queryKey 返回保存查询结果的有序集合，不存在时计算并缓存
+func queryKey(ctx context.Context, query GroupQuery, order string) (string, error) {
+	order, err := resolveOrder(ctx, order)
+	if err != nil {
+		return "", err
+	}
+
+	key := query.cacheKey(order)
+	exists, err := redis.Exists(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return key, nil
+	}
+
+	keys := []string{key, order}
+	for _, group := range query.Groups() {
+		keys = append(keys, "group:"+group)
+	}
+	if _, err := evalScript(ctx, queryScript, keys, len(query.All), len(query.Any), len(query.None), QueryCacheSeconds); err != nil {
+		return "", err
+	}
+
+	// 记录每个群组被哪些查询结果引用，群组成员变化时删除这些结果
+	pipe := redis.Pipeline()
+	for _, group := range query.Groups() {
+		pipe.SAdd(ctx, "group-queries:"+group, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// GetQueryArticles 按order分页获取满足多群组查询的文章
+func GetQueryArticles(ctx context.Context, query GroupQuery, order string, page int64) ([]*Article, error) {
+	key, err := queryKey(ctx, query, order)
+	if err != nil {
+		return nil, err
+	}
+	return GetArticle(ctx, page, key)
+}
+
+// GetQueryArticlesByCursor 基于游标分页获取满足多群组查询的文章
+func GetQueryArticlesByCursor(ctx context.Context, query GroupQuery, order, cursor string, size int64) (*ArticlePage, error) {
+	key, err := queryKey(ctx, query, order)
+	if err != nil {
+		return nil, err
+	}
+	return GetArticleByCursor(ctx, key, cursor, size)
+}
+
+// invalidateGroups 删除群组的排序缓存以及引用了这些群组的查询结果
+func invalidateGroups(ctx context.Context, groups ...string) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	pipe := redis.Pipeline()
+	cmds := make([]*goredis.StringSliceCmd, 0, len(groups))
+	for _, group := range groups {
+		cmds = append(cmds, pipe.SMembers(ctx, "group-queries:"+group))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0)
+	for i, group := range groups {
+		keys = append(keys, "score:"+group, "time:"+group, "group-queries:"+group)
+		for order := range TrendingWindows {
+			keys = append(keys, order+group)
+		}
+		keys = append(keys, cmds[i].Val()...)
+	}
+
+	return redis.Del(ctx, keys...)
+}
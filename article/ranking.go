@@ -0,0 +1,203 @@
+package article
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+)
+
+// Ranker 根据文章的投票情况和发布时间计算文章在score:中的评分。
+// 需要保留KARMAWEIGHTED加权效果的算法应当使用Article.NetVotes而不是原始票数。
+type Ranker interface {
+	Name() string
+	Score(article *Article, now int64) float64
+}
+
+// LinearRanker 默认的评分算法：发布时间 + VoteScore * 净票数，
+// 一篇文章需要获得200张赞成票才能在首页停留一天（86400 / 432 = 200）
+type LinearRanker struct{}
+
+func (LinearRanker) Name() string { return "linear" }
+
+func (LinearRanker) Score(article *Article, _ int64) float64 {
+	return float64(article.Time) + VoteScore*article.NetVotes()
+}
+
+// GravityRanker Hacker News的评分算法：(净票数 - 1) / (小时数 + 2) ^ Gravity，
+// 评分随时间衰减，必须定期批量重新计算
+type GravityRanker struct {
+	Gravity float64
+}
+
+func (GravityRanker) Name() string { return "gravity" }
+
+func (r GravityRanker) Score(article *Article, now int64) float64 {
+	hours := math.Max(float64(now-article.Time), 0) / 3600
+	return (article.NetVotes() - 1) / math.Pow(hours+2, r.Gravity)
+}
+
+// redditEpoch reddit热度算法的起始时间 2005-12-08 07:46:43 UTC
+const redditEpoch = 1134028003
+
+// RedditHotRanker reddit的热度算法：净票数取对数后加上发布时间，
+// 评分只依赖发布时间，不需要随时间重新计算
+type RedditHotRanker struct{}
+
+func (RedditHotRanker) Name() string { return "hot" }
+
+func (RedditHotRanker) Score(article *Article, _ int64) float64 {
+	s := article.NetVotes()
+	order := math.Log10(math.Max(math.Abs(s), 1))
+
+	sign := 0.0
+	if s > 0 {
+		sign = 1
+	} else if s < 0 {
+		sign = -1
+	}
+
+	seconds := float64(article.Time - redditEpoch)
+	return math.Round((sign*order+seconds/45000)*1e7) / 1e7
+}
+
+// WilsonRanker 以赞成票比例的威尔逊置信区间下界作为评分，适合按质量而不是热度排序，
+// Z为置信水平对应的分位数，95%置信水平为1.96。
+// 置信区间需要真实的投票人数，因此使用原始票数，不受KARMAWEIGHTED的影响
+type WilsonRanker struct {
+	Z float64
+}
+
+func (WilsonRanker) Name() string { return "wilson" }
+
+func (r WilsonRanker) Score(article *Article, _ int64) float64 {
+	n := float64(article.Votes + article.Downvotes)
+	if n == 0 {
+		return 0
+	}
+
+	z2 := r.Z * r.Z
+	phat := float64(article.Votes) / n
+	return (phat + z2/(2*n) - r.Z*math.Sqrt((phat*(1-phat)+z2/(4*n))/n)) / (1 + z2/n)
+}
+
+// Rankers 可以选择的评分算法
+var Rankers = map[string]Ranker{
+	"linear":  LinearRanker{},
+	"gravity": GravityRanker{Gravity: 1.8},
+	"hot":     RedditHotRanker{},
+	"wilson":  WilsonRanker{Z: 1.96},
+}
+
+// SetRanker 根据名称选择站点使用的评分算法，切换后需要调用RescoreAll重新计算已有文章的评分。
+// 评分算法保存在散列site:的ranker字段中，所有进程都读取同一个设置，未设置时使用线性评分。
+func SetRanker(ctx context.Context, name string) error {
+	if _, ok := Rankers[name]; !ok {
+		return fmt.Errorf("unknown ranker %q", name)
+	}
+
+	_, err := redis.HSet(ctx, "site:", "ranker", name)
+	return err
+}
+
+// GetRanker 返回站点当前使用的评分算法
+func GetRanker(ctx context.Context) (Ranker, error) {
+	site, err := redis.HGetAll(ctx, "site:")
+	if err != nil {
+		return nil, err
+	}
+
+	name := site["ranker"]
+	if name == "" {
+		return LinearRanker{}, nil
+	}
+	ranker, ok := Rankers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown ranker %q", name)
+	}
+	return ranker, nil
+}
+
+// rankerArgs 返回投票脚本计算评分所需的算法名称和参数，脚本不支持的算法返回false。
+// 线性评分在脚本中增量更新，其他内置算法在脚本中根据投票后的票数重新计算
+func rankerArgs(ranker Ranker) ([]interface{}, bool) {
+	switch r := ranker.(type) {
+	case LinearRanker:
+		return []interface{}{r.Name(), 0}, true
+	case GravityRanker:
+		return []interface{}{r.Name(), r.Gravity}, true
+	case RedditHotRanker:
+		return []interface{}{r.Name(), redditEpoch}, true
+	case WilsonRanker:
+		return []interface{}{r.Name(), r.Z}, true
+	default:
+		return nil, false
+	}
+}
+
+// Rescore 使用ranker重新计算指定文章的评分，keys为article:<id>。
+// 文章散列中记录了加权后的净票数，重新计算不会丢失KARMAWEIGHTED的加权效果，没有记录的旧文章按原始票数计算
+func Rescore(ctx context.Context, ranker Ranker, keys ...string) error {
+	articles, fetchErr := FetchArticles(ctx, keys)
+	if len(articles) == 0 {
+		return fetchErr
+	}
+
+	now := time.Now().Unix()
+	pipe := redis.Pipeline()
+	for _, article := range articles {
+		pipe.ZAdd(ctx, "score:", redis.Z{Score: ranker.Score(article, now), Member: article.Key()})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Join(fetchErr, err)
+	}
+
+	return fetchErr
+}
+
+// RescoreAll 按发布时间分批遍历所有文章并重新计算评分，返回处理的文章数量。
+// 遍历time:而不是score:，避免评分变化导致排名移动而遗漏或重复处理文章。
+// 已冻结的文章保留冻结时的评分，不重新计算。
+func RescoreAll(ctx context.Context, ranker Ranker, batch int64) (int64, error) {
+	if batch <= 0 {
+		batch = 100
+	}
+
+	var count int64
+	var errs []error
+	for start := int64(0); ; start += batch {
+		keys, err := redis.ZRange(ctx, "time:", start, start+batch-1)
+		if err != nil {
+			return count, err
+		}
+		if len(keys) == 0 {
+			break
+		}
+
+		archived, err := zmScore(ctx, "archived:", keys)
+		if err != nil {
+			return count, err
+		}
+		active := make([]string, 0, len(keys))
+		for i, key := range keys {
+			if archived[i] == 0 {
+				active = append(active, key)
+			}
+		}
+		if len(active) == 0 {
+			continue
+		}
+
+		if err := Rescore(ctx, ranker, active...); err != nil {
+			logs.Warnw("failed to rescore articles", "ranker", ranker.Name(), "start", start, "error", err)
+			errs = append(errs, err)
+		}
+		count += int64(len(active))
+	}
+
+	return count, errors.Join(errs...)
+}
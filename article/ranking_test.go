@@ -0,0 +1,75 @@
+package article
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankers(t *testing.T) {
+	now := int64(1700000000)
+	fresh := &Article{Time: now, Votes: 10}
+	old := &Article{Time: now - 86400, Votes: 10}
+
+	assert.Equal(t, float64(now+10*VoteScore), LinearRanker{}.Score(fresh, now))
+
+	gravity := GravityRanker{Gravity: 1.8}
+	assert.Greater(t, gravity.Score(fresh, now), gravity.Score(old, now))
+
+	hot := RedditHotRanker{}
+	assert.Greater(t, hot.Score(fresh, now), hot.Score(old, now))
+	assert.Greater(t, hot.Score(&Article{Time: now, Votes: 100}, now), hot.Score(fresh, now))
+
+	wilson := WilsonRanker{Z: 1.96}
+	assert.Equal(t, float64(0), wilson.Score(&Article{}, now))
+	assert.Greater(t, wilson.Score(&Article{Votes: 100, Downvotes: 10}, now), wilson.Score(&Article{Votes: 10, Downvotes: 1}, now))
+}
+
+func TestNetVotes(t *testing.T) {
+	data := map[string]string{"time": "1700000000", "votes": "3", "downvotes": "1"}
+	article, err := DecodeArticle("article:1", data)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), article.NetVotes())
+
+	// 加权后的净票数优先于原始票数
+	data["weighted"] = "3.5"
+	article, err = DecodeArticle("article:1", data)
+	assert.NoError(t, err)
+	assert.Equal(t, 3.5, article.NetVotes())
+	assert.Equal(t, float64(1700000000)+3.5*VoteScore, LinearRanker{}.Score(article, 0))
+}
+
+func TestSetRanker(t *testing.T) {
+	ctx := context.Background()
+	defer func() { _, _ = redis.HDel(ctx, "site:", "ranker") }()
+
+	assert.NoError(t, SetRanker(ctx, "hot"))
+	ranker, err := GetRanker(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "hot", ranker.Name())
+	assert.Error(t, SetRanker(ctx, "unknown"))
+}
+
+func TestVoteScore(t *testing.T) {
+	ctx := context.Background()
+	defer func() { _, _ = redis.HDel(ctx, "site:", "ranker") }()
+
+	// 投票脚本计算的评分与Score的结果一致
+	for name, ranker := range Rankers {
+		assert.NoError(t, SetRanker(ctx, name))
+
+		articleId, err := PostArticle(ctx, "username", "a title", "https://g.cn")
+		assert.NoError(t, err)
+		articleKey := "article:" + articleId
+		assert.NoError(t, ArticleVote(ctx, articleKey, "user_a"))
+		assert.NoError(t, ArticleDownvote(ctx, articleKey, "user_b"))
+		assert.NoError(t, ArticleVote(ctx, articleKey, "user_c"))
+
+		articles, err := FetchArticles(ctx, []string{articleKey})
+		assert.NoError(t, err)
+		assert.InDelta(t, ranker.Score(articles[0], time.Now().Unix()), articles[0].Score, 1e-3, name)
+	}
+}
@@ -0,0 +1,141 @@
+package article
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	// RelatedCandidates 计算相关文章时参与比较的最新文章数量
+	RelatedCandidates = 500
+	// RelatedCacheSeconds 相关文章的缓存时间，过期后在下一次访问时重新计算
+	RelatedCacheSeconds = 600
+)
+
+// relatedPlaceholder 没有相关文章时缓存的占位成员，避免每次访问都重新计算
+const relatedPlaceholder = ""
+
+// GetRelatedArticles 获取"为这篇文章投票的读者也为以下文章投了票"，按共同投票人数从多到少排序。
+// 结果缓存在related:<id>中，缓存不存在时重新计算。
+func GetRelatedArticles(ctx context.Context, articleId string, n int64) ([]*Article, error) {
+	key := "related:" + articleId
+	exists, err := redis.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := RefreshRelated(ctx, articleId); err != nil {
+			return nil, err
+		}
+	}
+
+	ids, err := redis.ZRevRange(ctx, key, 0, n-1)
+	if err != nil {
+		return nil, err
+	}
+	ids = slices.DeleteFunc(ids, func(id string) bool { return id == relatedPlaceholder })
+
+	return fetchListedArticles(ctx, key, ids)
+}
+
+// RefreshRelated 重新计算文章的相关文章：与投票期内最新的RelatedCandidates篇文章的投票名单求交集，
+// 以共同投票人数作为相关度。发布文章时发布者自动投了赞成票，两篇文章的发布者不计入共同投票人数。
+func RefreshRelated(ctx context.Context, articleId string) error {
+	article := "article:" + articleId
+	voted := "voted:" + articleId
+	key := "related:" + articleId
+
+	// 只有投票期内的文章还保留着投票名单
+	cutoff := strconv.FormatInt(time.Now().Unix()-OneWeekInSeconds, 10)
+	pipe := redis.Pipeline()
+	recentCmd := pipe.ZRevRangeByScore(ctx, "time:", &redis.ZRangeBy{Max: "+inf", Min: cutoff, Count: RelatedCandidates})
+	posterCmd := pipe.HGetAll(ctx, article)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if len(posterCmd.Val()) == 0 {
+		return fmt.Errorf("%w: %s", ErrArticleNotFound, article)
+	}
+	poster := posterCmd.Val()["poster"]
+
+	candidates := make([]string, 0, len(recentCmd.Val()))
+	for _, candidate := range recentCmd.Val() {
+		if candidate != article {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	related := make([]redis.Z, 0)
+	if len(candidates) > 0 {
+		pipe = redis.Pipeline()
+		counts := make([]*goredis.IntCmd, 0, len(candidates))
+		posters := make([]*goredis.StringCmd, 0, len(candidates))
+		posterVoted := make([]*goredis.BoolCmd, 0, len(candidates))
+		for _, candidate := range candidates {
+			candidateVoted := "voted:" + strings.TrimPrefix(candidate, "article:")
+			counts = append(counts, pipe.SInterCard(ctx, 0, voted, candidateVoted))
+			posters = append(posters, pipe.HGet(ctx, candidate, "poster"))
+			posterVoted = append(posterVoted, pipe.SIsMember(ctx, candidateVoted, poster))
+		}
+		// 发布者可能已经撤销了对自己文章的投票，只有同时在两份名单中才计入了共同投票人数
+		selfVoted := pipe.SIsMember(ctx, voted, poster)
+		// 被删除的候选文章HGET返回错误，发布者按空处理
+		_, _ = pipe.Exec(ctx)
+		if err := selfVoted.Err(); err != nil {
+			return err
+		}
+
+		// 候选文章的发布者是否同时为两篇文章投过票
+		pipe = redis.Pipeline()
+		candidatePosters := make([]interface{}, 0, len(candidates))
+		candidateSelfVoted := make([]*goredis.BoolCmd, 0, len(candidates))
+		for i, cmd := range posters {
+			candidatePosters = append(candidatePosters, cmd.Val())
+			candidateVoted := "voted:" + strings.TrimPrefix(candidates[i], "article:")
+			candidateSelfVoted = append(candidateSelfVoted, pipe.SIsMember(ctx, candidateVoted, cmd.Val()))
+		}
+		votedCmd := pipe.SMIsMember(ctx, voted, candidatePosters...)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		for i, cmd := range counts {
+			if err := cmd.Err(); err != nil {
+				return err
+			}
+			count := cmd.Val()
+			if selfVoted.Val() && posterVoted[i].Val() {
+				count--
+			}
+			candidatePoster := posters[i].Val()
+			if candidatePoster != poster && votedCmd.Val()[i] && candidateSelfVoted[i].Val() {
+				count--
+			}
+			if count > 0 {
+				related = append(related, redis.Z{Score: float64(count), Member: candidates[i]})
+			}
+		}
+	}
+
+	if len(related) == 0 {
+		related = append(related, redis.Z{Score: 0, Member: relatedPlaceholder})
+	}
+
+	// 在同一个事务里替换缓存，读取方不会看到计算了一半的结果
+	return redis.Watch(ctx, func(tx *redis.Tx) error {
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.ZAdd(ctx, key, related...)
+			pipe.Expire(ctx, key, RelatedCacheSeconds*time.Second)
+			return nil
+		})
+		return err
+	})
+}
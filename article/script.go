@@ -0,0 +1,30 @@
+package article
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/chaos-io/chaos/redis"
+)
+
+// scriptShas 缓存已加载脚本的sha1，避免每次调用都执行SCRIPT LOAD
+var scriptShas sync.Map
+
+// evalScript 通过EVALSHA执行lua脚本，脚本缓存被清空时会自动重新加载
+func evalScript(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	if sha1, ok := scriptShas.Load(script); ok {
+		res, err := redis.EvalSha(ctx, sha1.(string), keys, args...)
+		if err == nil || !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+			return res, err
+		}
+	}
+
+	sha1, err := redis.ScriptLoad(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+	scriptShas.Store(script, sha1)
+
+	return redis.EvalSha(ctx, sha1, keys, args...)
+}
@@ -0,0 +1,271 @@
+package article
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// SearchCacheSeconds 搜索结果的缓存时间
+const SearchCacheSeconds = 60
+
+// searchVersionKey 倒排索引的版本号，索引每次变化都会递增，搜索结果的缓存键包含版本号，
+// 发布、修改、删除文章以及重建索引之后不会再读到旧的缓存
+const searchVersionKey = "search-version:"
+
+// ErrEmptyQuery 搜索条件中没有可以检索的词
+var ErrEmptyQuery = errors.New("empty search query")
+
+// STEMMING 为true时对词做简单的词干提取，修改后需要调用ReindexTitles重建索引
+var STEMMING = false
+
+// stopWords 不会被索引的常见词
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "from": true, "how": true, "if": true, "in": true, "into": true, "is": true,
+	"it": true, "its": true, "no": true, "not": true, "of": true, "on": true, "or": true, "so": true,
+	"that": true, "the": true, "their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "which": true, "who": true,
+	"why": true, "will": true, "with": true, "you": true, "your": true,
+}
+
+// Tokenize 将文本切分成去重后的索引词：转换成小写、按非字母数字切分、去掉停用词和单个字符
+func Tokenize(text string) []string {
+	seen := make(map[string]bool)
+	tokens := make([]string, 0)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if token := normalize(word); token != "" && !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func normalize(word string) string {
+	if len([]rune(word)) < 2 || stopWords[word] {
+		return ""
+	}
+	if STEMMING {
+		return stem(word)
+	}
+	return word
+}
+
+// stem 去掉英文单词常见的后缀
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return strings.TrimSuffix(word, "ies") + "y"
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		return strings.TrimSuffix(word, "ing")
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		return strings.TrimSuffix(word, "ed")
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return strings.TrimSuffix(word, "s")
+	}
+	return word
+}
+
+// indexTitle 在流水线中更新文章的倒排索引idx:<token>并递增索引的版本号，
+// oldTitle为空表示新文章，title为空表示从索引中删除文章
+func indexTitle(ctx context.Context, pipe redis.Pipeliner, article, oldTitle, title string) {
+	pipe.Incr(ctx, searchVersionKey)
+	tokens := Tokenize(title)
+	for _, token := range Tokenize(oldTitle) {
+		if !slices.Contains(tokens, token) {
+			pipe.SRem(ctx, "idx:"+token, article)
+		}
+	}
+	for _, token := range tokens {
+		pipe.SAdd(ctx, "idx:"+token, article)
+	}
+}
+
+// ReindexTitles 按发布时间分批遍历所有文章，将标题加入倒排索引，返回处理的文章数量。
+// 用于为引入全文索引之前发布的文章建立索引，重复执行没有副作用。
+// 只会添加索引词，修改STEMMING之后旧的索引词仍然保留，需要先删除idx:*再重建。
+func ReindexTitles(ctx context.Context, batch int64) (int, error) {
+	if batch <= 0 {
+		batch = 100
+	}
+
+	count := 0
+	for start := int64(0); ; start += batch {
+		keys, err := redis.ZRange(ctx, "time:", start, start+batch-1)
+		if err != nil {
+			return count, err
+		}
+		if len(keys) == 0 {
+			return count, nil
+		}
+
+		pipe := redis.Pipeline()
+		titles := make([]*goredis.StringCmd, 0, len(keys))
+		for _, key := range keys {
+			titles = append(titles, pipe.HGet(ctx, key, "title"))
+		}
+		// 遍历过程中被删除的文章HGET返回错误，直接跳过
+		_, _ = pipe.Exec(ctx)
+
+		pipe = redis.Pipeline()
+		for i, key := range keys {
+			if title := titles[i].Val(); title != "" {
+				indexTitle(ctx, pipe, key, "", title)
+				count++
+			}
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return count, err
+		}
+	}
+}
+
+// SearchQuery 解析后的搜索条件：All中的每一组词至少要匹配其中一个（组与组之间是AND，组内是OR），
+// 不能匹配None中的任何词
+type SearchQuery struct {
+	All  [][]string
+	None []string
+}
+
+// ParseQuery 解析搜索语句，词之间默认是AND，以+开头的词与前一个词是OR，以-开头的词表示NOT。
+// 例如 "redis +cache -memcached" 表示包含redis或cache，并且不包含memcached。
+func ParseQuery(query string) SearchQuery {
+	var parsed SearchQuery
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		prefix := word[0]
+		if prefix == '+' || prefix == '-' {
+			word = word[1:]
+		}
+
+		tokens := Tokenize(word)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		switch {
+		case prefix == '-':
+			parsed.None = append(parsed.None, tokens...)
+		case prefix == '+' && len(parsed.All) > 0:
+			last := len(parsed.All) - 1
+			parsed.All[last] = append(parsed.All[last], tokens...)
+		default:
+			// 一个词被切分成多个索引词时，这些索引词都必须匹配
+			for _, token := range tokens {
+				parsed.All = append(parsed.All, []string{token})
+			}
+		}
+	}
+	return parsed
+}
+
+// cacheKey 为搜索条件生成确定的缓存键，version为倒排索引的版本号
+func (q SearchQuery) cacheKey(version, order string) string {
+	groups := make([]string, 0, len(q.All))
+	for _, group := range q.All {
+		sorted := append([]string(nil), group...)
+		sort.Strings(sorted)
+		groups = append(groups, strings.Join(sorted, "|"))
+	}
+	sort.Strings(groups)
+
+	none := append([]string(nil), q.None...)
+	sort.Strings(none)
+	return "search:" + version + ":" + order + strings.Join(groups, ",") + "-" + strings.Join(none, ",")
+}
+
+// Search 搜索标题匹配query的文章，并按order分页返回
+func Search(ctx context.Context, query, order string, page int64) ([]*Article, error) {
+	key, err := searchKey(ctx, ParseQuery(query), order)
+	if err != nil {
+		return nil, err
+	}
+	return GetArticle(ctx, page, key)
+}
+
+// SearchByCursor 搜索标题匹配query的文章，并按order基于游标分页返回
+func SearchByCursor(ctx context.Context, query, order, cursor string, size int64) (*ArticlePage, error) {
+	key, err := searchKey(ctx, ParseQuery(query), order)
+	if err != nil {
+		return nil, err
+	}
+	return GetArticleByCursor(ctx, key, cursor, size)
+}
+
+// searchKey 返回保存搜索结果的有序集合，不存在时根据倒排索引计算并缓存
+func searchKey(ctx context.Context, query SearchQuery, order string) (string, error) {
+	if len(query.All) == 0 {
+		return "", ErrEmptyQuery
+	}
+
+	order, err := resolveOrder(ctx, order)
+	if err != nil {
+		return "", err
+	}
+
+	// 监视版本号，计算期间索引发生变化时重新计算，不会把旧的结果缓存在新版本下
+	var key string
+	err = watchWithRetry(ctx, func(tx *redis.Tx) error {
+		version, err := tx.Get(ctx, searchVersionKey).Result()
+		if err != nil && !errors.Is(err, goredis.Nil) {
+			return err
+		}
+		key = query.cacheKey(version, order)
+		exists, err := tx.Exists(ctx, key).Result()
+		if err != nil || exists > 0 {
+			return err
+		}
+
+		// 组内的词求并集，组之间求交集，再减去不需要的词，最后与order求交集得到排序
+		matched := key + ":matched"
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			temps := []string{matched}
+			sets := make([]string, 0, len(query.All))
+			for i, group := range query.All {
+				if len(group) == 1 {
+					sets = append(sets, "idx:"+group[0])
+					continue
+				}
+
+				union := matched + ":" + strconv.Itoa(i)
+				keys := make([]string, 0, len(group))
+				for _, token := range group {
+					keys = append(keys, "idx:"+token)
+				}
+				pipe.SUnionStore(ctx, union, keys...)
+				sets = append(sets, union)
+				temps = append(temps, union)
+			}
+
+			pipe.SInterStore(ctx, matched, sets...)
+			if len(query.None) > 0 {
+				keys := []string{matched}
+				for _, token := range query.None {
+					keys = append(keys, "idx:"+token)
+				}
+				pipe.SDiffStore(ctx, matched, keys...)
+			}
+
+			pipe.ZInterStore(ctx, key, &redis.ZStore{Keys: []string{matched, order}, Weights: []float64{0, 1}})
+			pipe.Expire(ctx, key, SearchCacheSeconds*time.Second)
+			pipe.Del(ctx, temps...)
+			return nil
+		})
+		return err
+	}, searchVersionKey)
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,72 @@
+package article
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/chaos-io/chaos/redis"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"redis", "cache", "go"}, Tokenize("The Redis cache, in Go! redis"))
+
+	defer func() { STEMMING = false }()
+	STEMMING = true
+	assert.Equal(t, []string{"cach", "query", "story"}, Tokenize("caching queries stories"))
+}
+
+func TestParseQuery(t *testing.T) {
+	query := ParseQuery("redis +cache -memcached the go")
+	assert.Equal(t, [][]string{{"redis", "cache"}, {"go"}}, query.All)
+	assert.Equal(t, []string{"memcached"}, query.None)
+
+	assert.Equal(t, query.cacheKey("1", "score:"), ParseQuery("go cache +redis -memcached").cacheKey("1", "score:"))
+	assert.Empty(t, ParseQuery("the -redis").All)
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	word := "word" + strings.ToLower(ksuid.New().String())
+
+	a, err := PostArticle(ctx, "username", word+" redis", "https://g.cn")
+	assert.NoError(t, err)
+	b, err := PostArticle(ctx, "username", word+" memcached", "https://g.cn")
+	assert.NoError(t, err)
+
+	articles, err := Search(ctx, word+" -memcached", "score:", 1)
+	assert.NoError(t, err)
+	assert.Len(t, articles, 1)
+	assert.Equal(t, a, articles[0].Id)
+
+	// 发布和删除文章之后不会读到旧的缓存结果
+	c, err := PostArticle(ctx, "username", word+" cache", "https://g.cn")
+	assert.NoError(t, err)
+	articles, err = Search(ctx, word+" -memcached", "score:", 1)
+	assert.NoError(t, err)
+	assert.Len(t, articles, 2)
+	assert.NoError(t, DeleteArticle(ctx, c))
+	articles, err = Search(ctx, word+" -memcached", "score:", 1)
+	assert.NoError(t, err)
+	assert.Len(t, articles, 1)
+
+	_, err = Search(ctx, "the", "score:", 1)
+	assert.ErrorIs(t, err, ErrEmptyQuery)
+
+	// 引入全文索引之前发布的文章没有索引，重建之后可以搜索到
+	legacy := "legacy" + strings.ToLower(ksuid.New().String())
+	assert.NoError(t, UpdateArticle(ctx, b, legacy, ""))
+	_, err = redis.SRem(ctx, "idx:"+legacy, "article:"+b)
+	assert.NoError(t, err)
+
+	count, err := ReindexTitles(ctx, 0)
+	assert.NoError(t, err)
+	assert.Greater(t, count, 0)
+
+	articles, err = Search(ctx, legacy, "score:", 1)
+	assert.NoError(t, err)
+	assert.Len(t, articles, 1)
+	assert.Equal(t, b, articles[0].Id)
+}
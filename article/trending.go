@@ -0,0 +1,90 @@
+package article
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+)
+
+const (
+	// TrendingBucketSeconds 每个投票分桶votes:<bucket>覆盖的时间
+	TrendingBucketSeconds = 3600
+	// TrendingCacheSeconds 合并后的热门列表的缓存时间
+	TrendingCacheSeconds = 60
+)
+
+// TrendingWindow 热门列表的时间窗口：合并最近Buckets个分桶，每个分桶的权重随时间按HalfLife秒减半
+type TrendingWindow struct {
+	Buckets  int64
+	HalfLife float64
+}
+
+// TrendingWindows 可以作为GetArticle和GetGroupArticles的order使用的热门列表
+var TrendingWindows = map[string]TrendingWindow{
+	"trending:hour:": {Buckets: 2, HalfLife: 1800},
+	"trending:day:":  {Buckets: 24, HalfLife: 6 * 3600},
+	"trending:week:": {Buckets: 168, HalfLife: 86400},
+}
+
+// trendingBucket 返回now所在的投票分桶
+func trendingBucket(now int64) string {
+	return "votes:" + strconv.FormatInt(now/TrendingBucketSeconds*TrendingBucketSeconds, 10)
+}
+
+// resolveOrder 返回order对应的有序集合，热门列表不存在时合并投票分桶生成
+func resolveOrder(ctx context.Context, order string) (string, error) {
+	if order == "" {
+		return "score:", nil
+	}
+
+	window, ok := TrendingWindows[order]
+	if !ok {
+		return order, nil
+	}
+
+	exists, err := redis.Exists(ctx, order)
+	if err != nil || exists {
+		return order, err
+	}
+
+	keys, weights := trendingWeights(window, time.Now().Unix())
+	pipe := redis.Pipeline()
+	pipe.ZUnionStore(ctx, order, &redis.ZStore{Keys: keys, Weights: weights})
+	pipe.Expire(ctx, order, TrendingCacheSeconds*time.Second)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return order, err
+	}
+
+	return order, nil
+}
+
+// trendingWeights 计算窗口内每个分桶的衰减权重，分桶的年龄按分桶中点计算
+func trendingWeights(window TrendingWindow, now int64) ([]string, []float64) {
+	keys := make([]string, 0, window.Buckets)
+	weights := make([]float64, 0, window.Buckets)
+
+	current := now / TrendingBucketSeconds * TrendingBucketSeconds
+	for i := int64(0); i < window.Buckets; i++ {
+		start := current - i*TrendingBucketSeconds
+		age := math.Max(float64(now-start-TrendingBucketSeconds/2), 0)
+		keys = append(keys, trendingBucket(start))
+		weights = append(weights, math.Pow(0.5, age/window.HalfLife))
+	}
+
+	return keys, weights
+}
+
+// removeFromTrending 从仍未过期的投票分桶和缓存的热门列表中移除文章，文章被删除或移动到冷存储时调用
+func removeFromTrending(ctx context.Context, pipe redis.Pipeliner, article string, now int64) {
+	// 投票分桶在分桶开始一周之后才过期
+	current := now / TrendingBucketSeconds * TrendingBucketSeconds
+	for start := current; start >= current-OneWeekInSeconds-TrendingBucketSeconds; start -= TrendingBucketSeconds {
+		pipe.ZRem(ctx, trendingBucket(start), article)
+	}
+	for order := range TrendingWindows {
+		pipe.ZRem(ctx, order, article)
+	}
+}
@@ -0,0 +1,20 @@
+package article
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrendingWeights(t *testing.T) {
+	now := int64(1700000000)
+	keys, weights := trendingWeights(TrendingWindows["trending:day:"], now)
+
+	assert.Len(t, keys, 24)
+	assert.Equal(t, trendingBucket(now), keys[0])
+	assert.Equal(t, trendingBucket(now-TrendingBucketSeconds), keys[1])
+	for i := 1; i < len(weights); i++ {
+		assert.Less(t, weights[i], weights[i-1])
+	}
+	assert.LessOrEqual(t, weights[0], 1.0)
+}
@@ -0,0 +1,57 @@
+package article
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+)
+
+// GetUserPostedArticles 按发布时间从新到旧分页获取用户发布的文章
+func GetUserPostedArticles(ctx context.Context, user string, page int64) ([]*Article, error) {
+	return GetArticle(ctx, page, "posted:"+user)
+}
+
+// GetUserVotedArticles 按投票时间从新到旧分页获取用户投过赞成票或反对票的文章
+func GetUserVotedArticles(ctx context.Context, user string, direction VoteDirection, page int64) ([]*Article, error) {
+	switch direction {
+	case VoteUp:
+		return GetArticle(ctx, page, "user-voted:"+user)
+	case VoteDown:
+		return GetArticle(ctx, page, "user-downvoted:"+user)
+	default:
+		return nil, errors.New("direction must be VoteUp or VoteDown")
+	}
+}
+
+// DeleteUserVotes 注销账号时撤销用户的所有投票并调整文章评分，返回撤销的投票数量。
+// 投票期已结束的文章评分已经冻结，只从用户的索引中删除，不再调整评分。
+func DeleteUserVotes(ctx context.Context, user string) (int, error) {
+	count := 0
+	var errs []error
+	for _, key := range []string{"user-voted:" + user, "user-downvoted:" + user} {
+		articles, err := redis.ZRange(ctx, key, 0, -1)
+		if err != nil {
+			return count, err
+		}
+
+		for _, article := range articles {
+			err := ArticleUnvote(ctx, article, user)
+			switch {
+			case err == nil:
+				count++
+			case errors.Is(err, ErrVotingClosed), errors.Is(err, ErrArticleNotFound):
+			default:
+				logs.Warnw("failed to delete user vote", "user", user, "article", article, "error", err)
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return count, errors.Join(errs...)
+	}
+
+	return count, redis.Del(ctx, "user-voted:"+user, "user-downvoted:"+user)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/liankui/redis-playground/article"
+)
+
+// NewHandler 注册文章服务的所有JSON接口
+func NewHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /users", createUser)
+	mux.HandleFunc("POST /articles", postArticle)
+	mux.HandleFunc("GET /articles", listArticles)
+	mux.HandleFunc("GET /articles/{id}", getArticle)
+	mux.HandleFunc("POST /articles/{id}/vote", voteArticle)
+	mux.HandleFunc("PUT /articles/{id}/groups", updateGroups)
+	mux.HandleFunc("GET /articles/{id}/groups", getArticleGroups)
+	mux.HandleFunc("POST /groups", createGroup)
+	mux.HandleFunc("GET /groups", listGroups)
+	mux.HandleFunc("GET /groups/{group}/articles", listGroupArticles)
+	return mux
+}
+
+type createUserRequest struct {
+	User string `json:"user"`
+}
+
+type postArticleRequest struct {
+	User  string `json:"user"`
+	Title string `json:"title"`
+	Link  string `json:"link"`
+}
+
+type voteRequest struct {
+	User string `json:"user"`
+	// Direction 为up、down或none，默认为up
+	Direction string `json:"direction"`
+}
+
+type groupsRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+type createGroupRequest struct {
+	Name        string `json:"name"`
+	Creator     string `json:"creator"`
+	Description string `json:"description"`
+}
+
+type listResponse struct {
+	Articles []*article.Article `json:"articles"`
+	Next     string             `json:"next,omitempty"`
+}
+
+// createUser 注册用户，投票时根据注册时间判断账号是否满足最小注册时长
+func createUser(w http.ResponseWriter, r *http.Request) {
+	var req createUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.User == "" {
+		writeError(w, http.StatusBadRequest, "user is required")
+		return
+	}
+
+	if err := article.RegisterUser(r.Context(), req.User, time.Now()); err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"user": req.User})
+}
+
+func postArticle(w http.ResponseWriter, r *http.Request) {
+	var req postArticleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.User == "" || req.Title == "" || req.Link == "" {
+		writeError(w, http.StatusBadRequest, "user, title and link are required")
+		return
+	}
+
+	articleId, err := article.PostArticle(r.Context(), req.User, req.Title, req.Link)
+	if err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": articleId})
+}
+
+func getArticle(w http.ResponseWriter, r *http.Request) {
+	articles, err := article.FetchArticles(r.Context(), []string{"article:" + r.PathValue("id")})
+	if err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, articles[0])
+}
+
+func listArticles(w http.ResponseWriter, r *http.Request) {
+	order, ok := parseOrder(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "order must be score, time, hour, day or week")
+		return
+	}
+
+	if r.URL.Query().Has("cursor") || r.URL.Query().Has("size") {
+		size, _ := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+		page, err := article.GetArticleByCursor(r.Context(), order, r.URL.Query().Get("cursor"), size)
+		writeList(w, page, err)
+		return
+	}
+
+	page, ok := parsePage(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "page must be a positive integer")
+		return
+	}
+
+	articles, err := article.GetArticle(r.Context(), page, order)
+	writeList(w, &article.ArticlePage{Articles: articles}, err)
+}
+
+func listGroupArticles(w http.ResponseWriter, r *http.Request) {
+	order, ok := parseOrder(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "order must be score, time, hour, day or week")
+		return
+	}
+	group := r.PathValue("group")
+
+	if r.URL.Query().Has("cursor") || r.URL.Query().Has("size") {
+		size, _ := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+		page, err := article.GetGroupArticlesByCursor(r.Context(), group, order, r.URL.Query().Get("cursor"), size)
+		writeList(w, page, err)
+		return
+	}
+
+	page, ok := parsePage(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "page must be a positive integer")
+		return
+	}
+
+	articles, err := article.GetGroupArticles(r.Context(), group, order, page)
+	writeList(w, &article.ArticlePage{Articles: articles}, err)
+}
+
+func voteArticle(w http.ResponseWriter, r *http.Request) {
+	var req voteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.User == "" {
+		writeError(w, http.StatusBadRequest, "user is required")
+		return
+	}
+
+	var direction article.VoteDirection
+	switch req.Direction {
+	case "", "up":
+		direction = article.VoteUp
+	case "down":
+		direction = article.VoteDown
+	case "none":
+		direction = article.VoteNone
+	default:
+		writeError(w, http.StatusBadRequest, "direction must be up, down or none")
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if err := article.GuardedVote(r.Context(), "article:"+r.PathValue("id"), req.User, ip, direction); err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func updateGroups(w http.ResponseWriter, r *http.Request) {
+	var req groupsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := article.AddRemoveGroups(r.Context(), r.PathValue("id"), req.Add, req.Remove); err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getArticleGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := article.GetArticleGroups(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]string{"groups": groups})
+}
+
+func createGroup(w http.ResponseWriter, r *http.Request) {
+	var req createGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	if err := article.CreateGroup(r.Context(), req.Name, req.Creator, req.Description); err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func listGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := article.ListGroups(r.Context())
+	if err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]*article.Group{"groups": groups})
+}
+
+// parseOrder 将查询参数order转换成有序集合的键，默认按评分排序，hour、day和week表示对应时间窗口的热门列表
+func parseOrder(r *http.Request) (string, bool) {
+	switch r.URL.Query().Get("order") {
+	case "", "score":
+		return "score:", true
+	case "time":
+		return "time:", true
+	case "hour", "day", "week":
+		return "trending:" + r.URL.Query().Get("order") + ":", true
+	default:
+		return "", false
+	}
+}
+
+func parsePage(r *http.Request) (int64, bool) {
+	value := r.URL.Query().Get("page")
+	if value == "" {
+		return 1, true
+	}
+
+	page, err := strconv.ParseInt(value, 10, 64)
+	return page, err == nil && page > 0
+}
+
+func writeList(w http.ResponseWriter, page *article.ArticlePage, err error) {
+	if err != nil {
+		writeArticleError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listResponse{Articles: page.Articles, Next: page.Next})
+}
+
+// statusOf 将文章服务的错误转换成HTTP状态码
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, article.ErrInvalidArticle), errors.Is(err, article.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, article.ErrArticleNotFound), errors.Is(err, article.ErrGroupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, article.ErrVotingClosed), errors.Is(err, article.ErrGroupExists),
+		errors.Is(err, article.ErrUserExists):
+		return http.StatusConflict
+	case errors.Is(err, article.ErrAccountTooNew):
+		return http.StatusForbidden
+	case errors.Is(err, article.ErrRateLimited):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeArticleError(w http.ResponseWriter, err error) {
+	status := statusOf(err)
+	if status == http.StatusInternalServerError {
+		// 不把后端的错误细节暴露给调用方
+		logs.Warnw("article api backend error", "error", err)
+		writeError(w, status, "internal error")
+		return
+	}
+
+	writeError(w, status, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logs.Warnw("failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liankui/redis-playground/article"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusOf(t *testing.T) {
+	assert.Equal(t, http.StatusBadRequest, statusOf(fmt.Errorf("%w: x", article.ErrInvalidArticle)))
+	assert.Equal(t, http.StatusNotFound, statusOf(fmt.Errorf("%w: article:1", article.ErrArticleNotFound)))
+	assert.Equal(t, http.StatusConflict, statusOf(fmt.Errorf("%w: article:1", article.ErrVotingClosed)))
+	assert.Equal(t, http.StatusConflict, statusOf(fmt.Errorf("%w: user", article.ErrUserExists)))
+	assert.Equal(t, http.StatusTooManyRequests, statusOf(fmt.Errorf("%w: user", article.ErrRateLimited)))
+	assert.Equal(t, http.StatusInternalServerError, statusOf(fmt.Errorf("connection refused")))
+}
+
+func TestBadRequests(t *testing.T) {
+	handler := NewHandler()
+
+	tests := []struct {
+		method, target, body string
+	}{
+		{http.MethodGet, "/articles?order=votes", ""},
+		{http.MethodGet, "/articles?page=0", ""},
+		{http.MethodPost, "/users", `{}`},
+		{http.MethodPost, "/articles", `{"user":"username"}`},
+		{http.MethodPost, "/articles/1/vote", `{"user":"username","direction":"sideways"}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, tt.target)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/chaos-io/chaos/logs"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "http listen address")
+	flag.Parse()
+
+	logs.Infow("article server listening", "addr", *addr)
+	if err := http.ListenAndServe(*addr, NewHandler()); err != nil {
+		logs.Warnw("article server stopped", "error", err)
+		os.Exit(1)
+	}
+}
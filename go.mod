@@ -6,6 +6,7 @@ replace github.com/chaos-io/chaos => ../../chaos-io/chaos
 
 require (
 	github.com/chaos-io/chaos v0.0.0-00010101000000-000000000000
+	github.com/redis/go-redis/v9 v9.0.5
 	github.com/segmentio/ksuid v1.0.4
 	github.com/stretchr/testify v1.8.4
 )
@@ -21,7 +22,6 @@ require (
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/redis/go-redis/v9 v9.0.5 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.26.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
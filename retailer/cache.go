@@ -0,0 +1,149 @@
+package retailer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+	"github.com/segmentio/ksuid"
+)
+
+var (
+	// CACHETTL 页面缓存的有效时间
+	CACHETTL = 300 * time.Second
+	// STALETTL 页面缓存失效之后仍然可以作为旧页面返回的时间，期间只有一个调用者重新生成页面
+	STALETTL = 60 * time.Second
+	// REBUILDLOCKTTL 跨进程重新生成页面的锁的过期时间，也是等待其他进程生成页面的最长时间
+	REBUILDLOCKTTL = 10 * time.Second
+)
+
+// pageFlights 合并同一个进程内对同一个页面的并发重新生成
+var pageFlights = &flightGroup{}
+
+// flightGroup 同一个键同时只执行一次函数，其他调用者等待并共享结果
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	val  string
+}
+
+// do 执行fn并返回结果，如果同一个键的fn正在执行，则等待它结束并返回它的结果
+func (g *flightGroup) do(key string, fn func() string) string {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.val
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.val = fn()
+	return call.val
+}
+
+// inFlight 同一个键的fn是否正在执行
+func (g *flightGroup) inFlight(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.calls[key]
+	return ok
+}
+
+// readPage 读取缓存的页面，返回页面内容以及页面是否仍然有效。
+// 页面缓存cache:<hash>的过期时间为CACHETTL+STALETTL，剩余时间不超过STALETTL时表示页面已经失效，
+// 由获得锁lock:cache:<hash>的调用者重新生成，其他调用者继续返回旧页面。
+func readPage(ctx context.Context, pageKey string) (string, bool) {
+	pipe := redis.Pipeline()
+	contentCmd := pipe.Get(ctx, pageKey)
+	ttlCmd := pipe.TTL(ctx, pageKey)
+	// 页面不存在时GET会返回错误，直接按没有缓存处理
+	_, _ = pipe.Exec(ctx)
+
+	content := contentCmd.Val()
+	return content, len(content) > 0 && ttlCmd.Val() > STALETTL
+}
+
+// releaseScript 只有锁仍然属于自己时才释放
+// KEYS: lock:<name>
+// ARGV: identifier
+const releaseScript = `
+if redis.call("get", KEYS[1]) == ARGV[1] then
+    return redis.call("del", KEYS[1])
+end
+return 0
+`
+
+// rebuildPage 获得重新生成页面的锁之后调用callback并缓存页面。
+// 锁被其他进程持有时，有旧页面就返回旧页面，否则等待其他进程生成页面。
+func rebuildPage(ctx context.Context, pageKey, request string, callback func(string) string, stale string) string {
+	lock := "lock:" + pageKey
+	identifier := ksuid.New().String()
+	acquired, err := redis.SetNX(ctx, lock, identifier, REBUILDLOCKTTL)
+	if err != nil {
+		logs.Warnw("failed to acquire page lock", "page", pageKey, "error", err)
+	}
+
+	if !acquired && err == nil {
+		if len(stale) > 0 {
+			return stale
+		}
+		if content, ok := waitPage(ctx, pageKey, lock); ok {
+			return content
+		}
+	}
+
+	// 获得锁之前其他进程可能刚刚生成了页面
+	content, fresh := readPage(ctx, pageKey)
+	if !fresh {
+		content = callback(request)
+		if _, err := redis.Set(ctx, pageKey, content, CACHETTL+STALETTL); err != nil {
+			logs.Warnw("failed to cache page", "page", pageKey, "error", err)
+		}
+	}
+	if acquired {
+		if _, err := evalScript(ctx, releaseScript, []string{lock}, identifier); err != nil {
+			logs.Warnw("failed to release page lock", "page", pageKey, "error", err)
+		}
+	}
+	return content
+}
+
+// waitPage 等待持有锁的进程生成页面，锁被释放或过期之后仍然没有页面时返回false
+func waitPage(ctx context.Context, pageKey, lock string) (string, bool) {
+	deadline := time.Now().Add(REBUILDLOCKTTL)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return "", false
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if content, fresh := readPage(ctx, pageKey); fresh {
+			return content, true
+		}
+		if exists, err := redis.Exists(ctx, lock); err != nil || !exists {
+			break
+		}
+	}
+
+	content, fresh := readPage(ctx, pageKey)
+	return content, fresh
+}
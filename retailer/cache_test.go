@@ -0,0 +1,76 @@
+package retailer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FlightGroup(t *testing.T) {
+	group := &flightGroup{}
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val := group.do("key", func() string {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(50 * time.Millisecond)
+				return "page"
+			})
+			assert.Equal(t, "page", val)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls)
+	assert.False(t, group.inFlight("key"))
+}
+
+func Test_CacheRequest(t *testing.T) {
+	ctx := context.Background()
+	item := ksuid.New().String()
+	request := "http://test.com/?item=" + item
+
+	// 商品的浏览次数排名需要大于0才会被缓存
+	_, _ = redis.ZIncrBy(ctx, "viewed:", -1e9, ksuid.New().String())
+	_, _ = redis.ZIncrBy(ctx, "viewed:", -1e9+1, item)
+
+	var calls int32
+	callback := func(string) string {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "content"
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "content", CacheRequest(ctx, request, callback))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), calls)
+
+	// 页面失效之后仍然返回旧页面，同时由一个调用者重新生成
+	pageKey := "cache:" + hashRequest(request)
+	_, err := redis.Expire(ctx, pageKey, STALETTL)
+	assert.Nil(t, err)
+	_, err = redis.SetNX(ctx, "lock:"+pageKey, "other", REBUILDLOCKTTL)
+	assert.Nil(t, err)
+	assert.Equal(t, "content", CacheRequest(ctx, request, callback))
+	assert.Equal(t, int32(1), calls)
+
+	assert.Nil(t, redis.Del(ctx, "lock:"+pageKey))
+	assert.Equal(t, "content", CacheRequest(ctx, request, callback))
+	assert.Equal(t, int32(2), calls)
+}
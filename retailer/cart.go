@@ -0,0 +1,303 @@
+package retailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// CARTTTL 购物车最后一次修改之后的保留时间
+var CARTTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrEmptyCart 购物车是空的，不能结算
+	ErrEmptyCart = errors.New("empty cart")
+	// ErrOutOfStock 商品库存不足
+	ErrOutOfStock = errors.New("out of stock")
+	// ErrCartChanged 结算期间购物车被反复修改
+	ErrCartChanged = errors.New("cart changed during checkout")
+	// ErrOrderNotFound 订单不存在
+	ErrOrderNotFound = errors.New("order not found")
+)
+
+// checkoutRetries 结算期间购物车发生变化时的最大重试次数
+const checkoutRetries = 3
+
+// cartScript 修改购物车中商品的数量，并在商品第一次加入购物车时记录价格快照。
+// 购物车cart:<session>记录商品的数量，cart-prices:<session>记录商品第一次加入购物车时的价格，
+// 之后商品调价不会影响购物车中的价格。
+// KEYS: cart:<session>, cart-prices:<session>, product:<item>
+// ARGV: item, count, 增量修改(1或0), ttl
+// 返回值: 商品修改之后的数量
+const cartScript = `
+local count = tonumber(ARGV[2])
+if ARGV[3] == "1" then
+    count = tonumber(redis.call("hget", KEYS[1], ARGV[1]) or 0) + count
+end
+if count <= 0 then
+    redis.call("hdel", KEYS[1], ARGV[1])
+    redis.call("hdel", KEYS[2], ARGV[1])
+    return 0
+end
+
+redis.call("hset", KEYS[1], ARGV[1], count)
+local price = redis.call("hget", KEYS[3], "price")
+if price then
+    redis.call("hsetnx", KEYS[2], ARGV[1], price)
+end
+redis.call("expire", KEYS[1], ARGV[4])
+redis.call("expire", KEYS[2], ARGV[4])
+return count
+`
+
+// AddToCart 将购物车中商品的数量设置为count，数量不大于0时从购物车中移除商品
+func AddToCart(ctx context.Context, session, item string, count int) error {
+	_, err := updateCart(ctx, session, item, count, false)
+	return err
+}
+
+// IncrCart 增加或减少购物车中商品的数量，返回修改之后的数量
+func IncrCart(ctx context.Context, session, item string, delta int) (int64, error) {
+	return updateCart(ctx, session, item, delta, true)
+}
+
+func updateCart(ctx context.Context, session, item string, count int, incr bool) (int64, error) {
+	mode := 0
+	if incr {
+		mode = 1
+	}
+
+	keys := []string{"cart:" + session, "cart-prices:" + session, "product:" + item}
+	res, err := evalScript(ctx, cartScript, keys, item, count, mode, int64(CARTTTL/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return res.(int64), nil
+}
+
+// CartItem 购物车或订单中的一个商品，价格以分为单位
+type CartItem struct {
+	Item  string `json:"item"`
+	Count int64  `json:"count"`
+	Price int64  `json:"price"`
+}
+
+// Cart 购物车中的商品以及总价
+type Cart struct {
+	Items []*CartItem `json:"items"`
+	Total int64       `json:"total"`
+}
+
+// GetCart 返回购物车中的商品，没有价格快照的商品按商品目录中的当前价格计算
+func GetCart(ctx context.Context, session string) (*Cart, error) {
+	pipe := redis.Pipeline()
+	countsCmd := pipe.HGetAll(ctx, "cart:"+session)
+	pricesCmd := pipe.HGetAll(ctx, "cart-prices:"+session)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	counts, prices := countsCmd.Val(), pricesCmd.Val()
+	items := make([]*CartItem, 0, len(counts))
+	missing := make([]*CartItem, 0)
+	for item, value := range counts {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		cartItem := &CartItem{Item: item, Count: count}
+		if price, ok := prices[item]; ok {
+			cartItem.Price, _ = strconv.ParseInt(price, 10, 64)
+		} else {
+			missing = append(missing, cartItem)
+		}
+		items = append(items, cartItem)
+	}
+
+	if len(missing) > 0 {
+		pipe = redis.Pipeline()
+		cmds := make([]*goredis.StringCmd, 0, len(missing))
+		for _, cartItem := range missing {
+			cmds = append(cmds, pipe.HGet(ctx, "product:"+cartItem.Item, "price"))
+		}
+		// 商品已经从目录中删除时价格为0
+		_, _ = pipe.Exec(ctx)
+		for i, cartItem := range missing {
+			price, _ := cmds[i].Result()
+			cartItem.Price, _ = strconv.ParseInt(price, 10, 64)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Item < items[j].Item })
+	cart := &Cart{Items: items}
+	for _, item := range items {
+		cart.Total += item.Count * item.Price
+	}
+	return cart, nil
+}
+
+// mergeCartScript 将一个购物车合并到另一个购物车，数量相加，价格快照以目标购物车为准
+// KEYS: cart:<from>, cart-prices:<from>, cart:<to>, cart-prices:<to>
+// ARGV: ttl
+// 返回值: 合并的商品种类数量
+const mergeCartScript = `
+local cart = redis.call("hgetall", KEYS[1])
+for i = 1, #cart, 2 do
+    redis.call("hincrby", KEYS[3], cart[i], cart[i + 1])
+end
+local prices = redis.call("hgetall", KEYS[2])
+for i = 1, #prices, 2 do
+    redis.call("hsetnx", KEYS[4], prices[i], prices[i + 1])
+end
+
+redis.call("del", KEYS[1], KEYS[2])
+if #cart > 0 then
+    redis.call("expire", KEYS[3], ARGV[1])
+    redis.call("expire", KEYS[4], ARGV[1])
+end
+return #cart / 2
+`
+
+// MergeCart 匿名会话登录之后，将匿名会话的购物车合并到用户会话的购物车中，返回合并的商品种类数量
+func MergeCart(ctx context.Context, from, to string) (int64, error) {
+	if from == to {
+		return 0, nil
+	}
+
+	keys := []string{"cart:" + from, "cart-prices:" + from, "cart:" + to, "cart-prices:" + to}
+	res, err := evalScript(ctx, mergeCartScript, keys, int64(CARTTTL/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return res.(int64), nil
+}
+
+// Order 结算购物车生成的订单
+type Order struct {
+	Id      string      `json:"id"`
+	User    string      `json:"user"`
+	Items   []*CartItem `json:"items"`
+	Total   int64       `json:"total"`
+	Created int64       `json:"created"`
+}
+
+// checkoutScript 检查库存并扣减，然后将购物车转换成订单
+// KEYS: cart:<session>, cart-prices:<session>, order:<id>, orders:<user>, product:<item>...
+// ARGV: user, now, orderId, item...
+// 返回值: {"ok", total}，{"empty"}，{"changed"}购物车中的商品与传入的不一致，
+// {"missing", item}商品不存在，{"stock", item}库存不足
+const checkoutScript = `
+local cart = redis.call("hgetall", KEYS[1])
+if #cart == 0 then
+    return {"empty"}
+end
+local n = #ARGV - 3
+if #cart / 2 ~= n then
+    return {"changed"}
+end
+local counts = {}
+for i = 1, #cart, 2 do
+    counts[cart[i]] = tonumber(cart[i + 1])
+end
+
+local items, total = {}, 0
+for i = 1, n do
+    local item = ARGV[i + 3]
+    local count = counts[item]
+    if not count then
+        return {"changed"}
+    end
+    local product = redis.call("hmget", KEYS[i + 4], "price", "stock")
+    if not product[1] then
+        return {"missing", item}
+    end
+    if tonumber(product[2] or 0) < count then
+        return {"stock", item}
+    end
+    local price = tonumber(redis.call("hget", KEYS[2], item) or product[1])
+    total = total + price * count
+    items[i] = {item = item, count = count, price = price}
+end
+
+for i = 1, n do
+    redis.call("hincrby", KEYS[i + 4], "stock", -items[i].count)
+end
+redis.call("hset", KEYS[3], "user", ARGV[1], "total", total, "created", ARGV[2], "items", cjson.encode(items))
+redis.call("zadd", KEYS[4], ARGV[2], ARGV[3])
+redis.call("del", KEYS[1], KEYS[2])
+return {"ok", tostring(total)}
+`
+
+// Checkout 结算购物车：原子地检查并扣减所有商品的库存，生成订单并清空购物车
+func Checkout(ctx context.Context, session, user string) (*Order, error) {
+	incrId, err := redis.Incr(ctx, "order:")
+	if err != nil {
+		return nil, err
+	}
+	orderId := strconv.FormatInt(incrId, 10)
+
+	for i := 0; i < checkoutRetries; i++ {
+		// 脚本需要事先声明所有商品的键，先读出购物车中的商品，脚本里再确认购物车没有变化
+		items, err := redis.HKeys(ctx, "cart:"+session)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return nil, ErrEmptyCart
+		}
+		sort.Strings(items)
+
+		keys := []string{"cart:" + session, "cart-prices:" + session, "order:" + orderId, "orders:" + user}
+		args := []interface{}{user, time.Now().Unix(), orderId}
+		for _, item := range items {
+			keys = append(keys, "product:"+item)
+			args = append(args, item)
+		}
+
+		res, err := evalScript(ctx, checkoutScript, keys, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		status := res.([]interface{})
+		switch status[0].(string) {
+		case "ok":
+			if err := refreshLowStock(ctx, items...); err != nil {
+				logs.Warnw("failed to refresh low stock", "order", orderId, "error", err)
+			}
+			return GetOrder(ctx, orderId)
+		case "empty":
+			return nil, ErrEmptyCart
+		case "missing":
+			return nil, fmt.Errorf("%w: %s", ErrProductNotFound, status[1])
+		case "stock":
+			return nil, fmt.Errorf("%w: %s", ErrOutOfStock, status[1])
+		}
+	}
+
+	return nil, ErrCartChanged
+}
+
+// GetOrder 返回订单信息
+func GetOrder(ctx context.Context, orderId string) (*Order, error) {
+	data, err := redis.HGetAll(ctx, "order:"+orderId)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrOrderNotFound, orderId)
+	}
+
+	order := &Order{Id: orderId, User: data["user"]}
+	order.Total, _ = strconv.ParseInt(data["total"], 10, 64)
+	order.Created, _ = strconv.ParseInt(data["created"], 10, 64)
+	if err := json.Unmarshal([]byte(data["items"]), &order.Items); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
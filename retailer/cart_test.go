@@ -0,0 +1,63 @@
+package retailer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Cart(t *testing.T) {
+	ctx := context.Background()
+	anonymous, session := ksuid.New().String(), ksuid.New().String()
+	user := ksuid.New().String()
+	apple, pear := ksuid.New().String(), ksuid.New().String()
+
+	assert.Nil(t, SetProduct(ctx, &Product{Id: apple, Name: "apple", Price: 300, Stock: 5}))
+	assert.Nil(t, SetProduct(ctx, &Product{Id: pear, Name: "pear", Price: 200, Stock: 1}))
+
+	assert.Nil(t, AddToCart(ctx, anonymous, apple, 2))
+	count, err := IncrCart(ctx, anonymous, apple, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// 商品调价不影响已经加入购物车的价格
+	assert.Nil(t, SetProduct(ctx, &Product{Id: apple, Name: "apple", Price: 500, Stock: 5}))
+	assert.Nil(t, AddToCart(ctx, session, pear, 2))
+
+	merged, err := MergeCart(ctx, anonymous, session)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), merged)
+
+	cart, err := GetCart(ctx, session)
+	assert.Nil(t, err)
+	assert.Len(t, cart.Items, 2)
+	assert.Equal(t, int64(3*300+2*200), cart.Total)
+
+	ttl, err := redis.TTL(ctx, "cart:"+session)
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+
+	_, err = Checkout(ctx, session, user)
+	assert.ErrorIs(t, err, ErrOutOfStock)
+
+	count, err = IncrCart(ctx, session, pear, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	order, err := Checkout(ctx, session, user)
+	assert.Nil(t, err)
+	assert.Equal(t, user, order.User)
+	assert.Equal(t, int64(3*300+200), order.Total)
+	assert.Len(t, order.Items, 2)
+
+	product, err := GetProduct(ctx, apple)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), product.Stock)
+
+	_, err = Checkout(ctx, session, user)
+	assert.ErrorIs(t, err, ErrEmptyCart)
+}
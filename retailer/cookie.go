@@ -5,18 +5,31 @@ import (
 	"crypto"
 	"encoding/hex"
 	"net/url"
-	"sync/atomic"
 	"time"
 
 	"github.com/chaos-io/chaos/logs"
 	"github.com/chaos-io/chaos/redis"
 )
 
-var (
-	QUIT        = false
-	LIMIT int64 = 10000000
-	FLAG  int32 = 1
-)
+// LIMIT 默认保留的令牌数量上限
+const LIMIT int64 = 10000000
+
+// CleanSessionJob 清理超出LIMIT的旧会话，可以用StartWorker并发运行多个实例
+var CleanSessionJob = NewCleanSessionJob(LIMIT)
+
+// NewCleanSessionJob 返回清理超出limit的旧会话的任务
+func NewCleanSessionJob(limit int64) Job {
+	return Job{
+		Name:     "clean-session",
+		Run:      func(ctx context.Context) (bool, error) { return cleanSession(ctx, limit) },
+		Interval: time.Second,
+	}
+}
+
+// RescaleViewedJob 定期缩减商品的浏览次数，多个实例同时运行时每个周期只有一个实例会执行
+var RescaleViewedJob = Job{Name: "rescale-viewed", Run: rescaleViewed, Interval: rescaleInterval}
+
+const rescaleInterval = 300 * time.Second
 
 func CheckToken(ctx context.Context, token string) (string, error) {
 	return redis.HGet(ctx, "login:", token)
@@ -58,59 +71,54 @@ func UpdateToken(ctx context.Context, token, user, item string) error {
 }
 
 // CleanSession 假设每天有500w用户访问，5000000/86400 = 58，需要每秒清理58个令牌，才能防止令牌过多问题发生。
+// CleanSession会一直运行直到ctx被取消。
 func CleanSession(ctx context.Context) {
-	for !QUIT {
-		// 获取目前已有令牌的数量
-		size, err := redis.ZCard(ctx, "recent:")
-		if err != nil {
-			logs.Warnw("failed to get recent: zcard", "error", err)
-		}
-
-		// 令牌数量未超过限制，休眠并在之后重新检查
-		if size <= LIMIT {
-			time.Sleep(time.Second)
-			continue
-		}
+	RunJob(ctx, CleanSessionJob)
+}
 
-		// 获取需要移除的令牌Id
-		endIndex := min(size-LIMIT, 100)
-		tokens, err := redis.ZRange(ctx, "recent:", 0, endIndex-1)
-		if err != nil {
-			logs.Warnw("failed to get recent: zrange", "error", err)
-		}
+func cleanSession(ctx context.Context, limit int64) (bool, error) {
+	// 获取目前已有令牌的数量
+	size, err := redis.ZCard(ctx, "recent:")
+	if err != nil {
+		return false, err
+	}
 
-		sessions := make([]string, 0, len(tokens))
-		for _, token := range tokens {
-			sessions = append(sessions, "viewed:"+token)
-			sessions = append(sessions, "cart:"+token)
-		}
+	// 令牌数量未超过限制，休眠并在之后重新检查
+	if size <= limit {
+		return false, nil
+	}
 
-		// 移除最旧的令牌
-		_ = redis.Del(ctx, sessions...)
-		_, _ = redis.HDel(ctx, "login:", tokens...)
-		_, _ = redis.ZRem(ctx, "recent:", tokens)
+	// 获取需要移除的令牌Id
+	endIndex := min(size-limit, 100)
+	tokens, err := redis.ZRange(ctx, "recent:", 0, endIndex-1)
+	if err != nil {
+		return false, err
 	}
 
-	defer atomic.AddInt32(&FLAG, -1)
-}
+	sessions := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, "viewed:"+token)
+		sessions = append(sessions, "cart:"+token, "cart-prices:"+token)
+	}
 
-func AddToCart(ctx context.Context, session, item string, count int) error {
-	if count <= 0 {
-		_, err := redis.HDel(ctx, "cart:"+session, item)
-		if err != nil {
-			return err
-		}
-	} else {
-		_, err := redis.HSet(ctx, "cart:"+session, item, count)
-		if err != nil {
-			return err
-		}
+	// 移除最旧的令牌，多个实例同时删除同一个令牌也没有影响
+	if err := redis.Del(ctx, sessions...); err != nil {
+		return false, err
 	}
-	return nil
+	if _, err := redis.HDel(ctx, "login:", tokens...); err != nil {
+		return false, err
+	}
+	if _, err := redis.ZRem(ctx, "recent:", tokens); err != nil {
+		return false, err
+	}
+
+	return true, nil
 }
 
 // CacheRequest 该缓存函数可以让网站在5分钟之内不再重复动态生成视图页面。
 // 查询本地redis延迟值通常低于1ms，查询位于同一个数据中心的延迟值通常低于5ms。
+// 页面失效时只有一个调用者重新生成页面，同一个进程内的其他调用者等待它的结果，
+// 其他进程的调用者在旧页面仍然可用时直接返回旧页面。
 func CacheRequest(ctx context.Context, request string, callback func(string) string) string {
 	// 对于不能被缓存的请求，直接调用回调函数
 	if !canCache(ctx, request) {
@@ -119,26 +127,43 @@ func CacheRequest(ctx context.Context, request string, callback func(string) str
 
 	// 将请求转换成一个简单的字符串键，方便之后查找
 	pageKey := "cache:" + hashRequest(request)
-	content, _ := redis.Get(ctx, pageKey)
+	content, fresh := readPage(ctx, pageKey)
+	if fresh {
+		return content
+	}
 
-	// 如何页面没有被缓存，调用函数并放到缓存里面
-	if len(content) == 0 {
-		content = callback(request)
-		_, _ = redis.Set(ctx, pageKey, content, 300*time.Second)
+	// 已经有调用者在重新生成页面，先返回旧页面
+	if len(content) > 0 && pageFlights.inFlight(pageKey) {
+		return content
 	}
 
-	// 返回页面
-	return content
+	// 如果页面没有被缓存或已经失效，调用函数并放到缓存里面
+	return pageFlights.do(pageKey, func() string {
+		return rebuildPage(ctx, pageKey, request, callback, content)
+	})
 }
 
+// RescaleViewed 每5分钟缩减一次商品的浏览次数，直到ctx被取消
 func RescaleViewed(ctx context.Context) {
-	for !QUIT {
-		// 删除所有排名在20000名之后的商品
-		_, _ = redis.ZRemRangeByRank(ctx, "viewed:", 20000, -1)
-		// 将浏览次数降低为原来的一半
-		_, _ = redis.ZInterStore(ctx, "viewed:", []string{"viewed:"}, []float64{0.5}, "")
-		time.Sleep(300 * time.Second)
+	RunJob(ctx, RescaleViewedJob)
+}
+
+func rescaleViewed(ctx context.Context) (bool, error) {
+	// 多个实例同时运行时，通过带过期时间的标记保证每个周期只缩减一次
+	if ok, err := redis.SetNX(ctx, "rescale-viewed:", time.Now().Unix(), rescaleInterval-time.Second); err != nil || !ok {
+		return false, err
 	}
+
+	// 删除所有排名在20000名之后的商品
+	if _, err := redis.ZRemRangeByRank(ctx, "viewed:", 20000, -1); err != nil {
+		return false, err
+	}
+	// 将浏览次数降低为原来的一半
+	if _, err := redis.ZInterStore(ctx, "viewed:", []string{"viewed:"}, []float64{0.5}, ""); err != nil {
+		return false, err
+	}
+
+	return false, nil
 }
 
 func canCache(ctx context.Context, request string) bool {
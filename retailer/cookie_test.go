@@ -22,13 +22,14 @@ func Test_Cookie(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, username, checkToken)
 
-	LIMIT = 0
-	go CleanSession(ctx)
+	worker := StartWorker(ctx, NewCleanSessionJob(0), 2)
 	time.Sleep(1 * time.Second)
-	QUIT = true
-	time.Sleep(2 * time.Second)
+	assert.True(t, worker.Healthy(time.Second))
+	worker.Stop()
 
-	assert.Equal(t, int32(0), FLAG)
+	stats := worker.Stats()
+	assert.Equal(t, 0, stats.Running)
+	assert.Greater(t, stats.Runs, int64(0))
 
 	hLen, err := redis.HLen(ctx, "login:")
 	assert.Nil(t, err)
@@ -39,8 +40,4 @@ func Test_Cookie(t *testing.T) {
 
 func reset(ctx context.Context) {
 	_, _ = redis.Do(ctx, "FLUSHDB")
-
-	QUIT = false
-	LIMIT = 10000000
-	FLAG = 1
 }
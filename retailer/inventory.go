@@ -3,6 +3,8 @@ package retailer
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/chaos-io/chaos/logs"
@@ -23,10 +25,51 @@ func NewInventory(id, data string, cached int64) Inventory {
 	}
 }
 
-func Get(id string) Inventory {
-	return NewInventory(id, "data to cache...", time.Now().Unix())
+// Get 从商品目录中读取需要缓存的商品数据
+func Get(ctx context.Context, id string) (Inventory, error) {
+	product, err := GetProduct(ctx, id)
+	if err != nil {
+		return Inventory{}, err
+	}
+
+	data, err := json.Marshal(product)
+	if err != nil {
+		return Inventory{}, err
+	}
+	return NewInventory(id, string(data), time.Now().Unix()), nil
+}
+
+// NewCacheRowsJob 创建缓存数据行的任务，数据行通过loader按policy重试读取。
+// 多个实例可以同时运行而不会重复处理同一行。
+func NewCacheRowsJob(loader RowLoader, policy RetryPolicy) Job {
+	return Job{
+		Name: "cache-rows",
+		Run: func(ctx context.Context) (bool, error) {
+			return cacheRow(ctx, loader, policy)
+		},
+		Interval: 50 * time.Millisecond,
+	}
 }
 
+// claimRowScript 取出下一个到期的数据行并立即按延迟时间重新调度，
+// 并发运行的多个实例不会取到同一行
+// KEYS: schedule:, delay:
+// ARGV: now
+// 返回值: 没有到期的数据行时返回空数组，否则返回{rowId, delay}
+const claimRowScript = `
+local next = redis.call("zrange", KEYS[1], 0, 0, "withscores")
+if #next == 0 or tonumber(next[2]) > tonumber(ARGV[1]) then
+    return {}
+end
+
+local rowId = next[1]
+local delay = tonumber(redis.call("zscore", KEYS[2], rowId) or 0)
+if delay > 0 then
+    redis.call("zadd", KEYS[1], tonumber(ARGV[1]) + delay, rowId)
+end
+return {rowId, tostring(delay)}
+`
+
 func ScheduleRowCache(ctx context.Context, rowId string, delay int64) {
 	// 先设置数据行的延迟值
 	_, _ = redis.ZAdd(ctx, "delay:", float64(delay), rowId)
@@ -34,34 +77,60 @@ func ScheduleRowCache(ctx context.Context, rowId string, delay int64) {
 	_, _ = redis.ZAdd(ctx, "schedule:", float64(time.Now().Unix()), rowId)
 }
 
+// CacheRows 从商品目录中读取到期的数据行并缓存，一直运行直到ctx被取消
 func CacheRows(ctx context.Context) {
-	for !QUIT {
-		now := time.Now().Unix()
-		// 尝试获取下一个需要被缓存的数据行以及该行的调度时间戳
-		next, _ := redis.ZRangeWithScores(ctx, "schedule:", 0, 0)
-		// 暂时没有行需要被缓存，休眠50ms后重试
-		if len(next) == 0 || next[0].Score > float64(now) {
-			time.Sleep(50 * time.Millisecond)
-			continue
-		}
+	RunJob(ctx, NewCacheRowsJob(CatalogLoader{}, DefaultRetryPolicy()))
+}
 
-		rowId := next[0].Member.(string)
-		// 提前获取下一次调度的延迟时间
-		delayScore, _ := redis.ZScore(ctx, "delay:", rowId)
-		if delayScore <= 0 {
-			_, _ = redis.ZRem(ctx, "delay:", rowId)
-			_, _ = redis.ZRem(ctx, "schedule:", rowId)
-			_ = redis.Del(ctx, "inv:"+rowId)
-			continue
-		}
+func cacheRow(ctx context.Context, loader RowLoader, policy RetryPolicy) (bool, error) {
+	// 尝试获取下一个需要被缓存的数据行以及该行的延迟时间
+	res, err := evalScript(ctx, claimRowScript, []string{"schedule:", "delay:"}, time.Now().Unix())
+	if err != nil {
+		return false, err
+	}
+
+	// 暂时没有行需要被缓存，休眠50ms后重试
+	next, _ := res.([]interface{})
+	if len(next) != 2 {
+		return false, nil
+	}
+
+	rowId := next[0].(string)
+	delay, _ := strconv.ParseFloat(next[1].(string), 64)
+	// 延迟值不大于0表示不再需要缓存这一行
+	if delay <= 0 {
+		_, _ = redis.ZRem(ctx, "delay:", rowId)
+		_, _ = redis.ZRem(ctx, "schedule:", rowId)
+		_, _ = redis.ZRem(ctx, "stale:", rowId)
+		return true, redis.Del(ctx, "inv:"+rowId)
+	}
 
-		row := Get(rowId)
-		_, _ = redis.ZAdd(ctx, "schedule:", float64(now), rowId)
-		jsonRow, err := json.Marshal(row)
-		if err != nil {
-			logs.Warnw("json marshal err", "error", err)
+	row, err := loadRow(ctx, loader, policy, rowId)
+	// 数据行已经从数据源中删除，移除缓存的旧数据
+	if errors.Is(err, ErrRowNotFound) {
+		pipe := redis.Pipeline()
+		pipe.Del(ctx, "inv:"+rowId)
+		pipe.ZRem(ctx, "stale:", rowId)
+		_, err := pipe.Exec(ctx)
+		return true, err
+	}
+	// 重试之后仍然失败，保留旧的缓存数据并记为过期，等下一次调度时再尝试
+	if err != nil {
+		if err := markStale(ctx, rowId); err != nil {
+			logs.Warnw("failed to mark row stale", "row", rowId, "error", err)
 		}
-		// 更新调度时间并设置缓存值
-		_, _ = redis.Set(ctx, "inv:"+rowId, jsonRow, 0)
+		return true, err
 	}
+
+	jsonRow, err := json.Marshal(row)
+	if err != nil {
+		return true, err
+	}
+
+	// 设置缓存值
+	pipe := redis.Pipeline()
+	pipe.Set(ctx, "inv:"+rowId, jsonRow, 0)
+	pipe.ZRem(ctx, "stale:", rowId)
+	_, err = pipe.Exec(ctx)
+	return true, err
 }
@@ -0,0 +1,98 @@
+package retailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+)
+
+// ErrRowNotFound 数据源中不存在该数据行
+var ErrRowNotFound = errors.New("row not found")
+
+// RowLoader 从数据源读取需要缓存的数据行，数据行不存在时返回ErrRowNotFound
+type RowLoader interface {
+	Load(ctx context.Context, rowId string) (Inventory, error)
+}
+
+// RetryPolicy 读取数据行失败时的重试策略，每次重试的等待时间翻倍，直到MaxBackoff
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy CacheRows读取数据行的默认重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{Attempts: 3, Backoff: 100 * time.Millisecond, MaxBackoff: 2 * time.Second}
+}
+
+// CatalogLoader 从商品目录中读取商品数据
+type CatalogLoader struct{}
+
+func (CatalogLoader) Load(ctx context.Context, rowId string) (Inventory, error) {
+	row, err := Get(ctx, rowId)
+	if errors.Is(err, ErrProductNotFound) {
+		return Inventory{}, fmt.Errorf("%w: %s", ErrRowNotFound, rowId)
+	}
+	return row, err
+}
+
+// FileLoader 从目录Dir中的<rowId>.json文件读取数据行，主要用于测试
+type FileLoader struct {
+	Dir string
+}
+
+func (l FileLoader) Load(ctx context.Context, rowId string) (Inventory, error) {
+	// 防止rowId中的路径穿越到目录之外
+	if rowId != filepath.Base(rowId) {
+		return Inventory{}, fmt.Errorf("%w: %s", ErrRowNotFound, rowId)
+	}
+
+	data, err := os.ReadFile(filepath.Join(l.Dir, rowId+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return Inventory{}, fmt.Errorf("%w: %s", ErrRowNotFound, rowId)
+	}
+	if err != nil {
+		return Inventory{}, err
+	}
+	return NewInventory(rowId, string(data), time.Now().Unix()), nil
+}
+
+// loadRow 按重试策略读取数据行，数据行不存在时不重试
+func loadRow(ctx context.Context, loader RowLoader, policy RetryPolicy, rowId string) (Inventory, error) {
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		row, err := loader.Load(ctx, rowId)
+		if err == nil || errors.Is(err, ErrRowNotFound) || attempt >= policy.Attempts {
+			return row, err
+		}
+		logs.Infow("failed to load row, retrying", "row", rowId, "attempt", attempt, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return row, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, policy.MaxBackoff)
+	}
+}
+
+// StaleRows 返回缓存数据已经过期的数据行数量。
+// 数据行读取失败时缓存中仍然是旧数据，这些数据行记录在有序集合stale:中，分值为第一次读取失败的时间，
+// 下一次成功缓存之后移除。
+func StaleRows(ctx context.Context) (int64, error) {
+	return redis.ZCard(ctx, "stale:")
+}
+
+func markStale(ctx context.Context, rowId string) error {
+	pipe := redis.Pipeline()
+	pipe.ZAddNX(ctx, "stale:", redis.Z{Score: float64(time.Now().Unix()), Member: rowId})
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,115 @@
+package retailer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyLoader 前failures次读取失败
+type flakyLoader struct {
+	failures int
+	calls    int
+}
+
+func (l *flakyLoader) Load(ctx context.Context, rowId string) (Inventory, error) {
+	l.calls++
+	if l.calls <= l.failures {
+		return Inventory{}, errors.New("connection refused")
+	}
+	return NewInventory(rowId, "data", time.Now().Unix()), nil
+}
+
+func Test_FileLoader(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "row1.json"), []byte(`{"name":"apple"}`), 0o644))
+
+	loader := FileLoader{Dir: dir}
+	row, err := loader.Load(ctx, "row1")
+	assert.Nil(t, err)
+	assert.Equal(t, "row1", row.Id)
+	assert.Equal(t, `{"name":"apple"}`, row.Data)
+
+	_, err = loader.Load(ctx, "row2")
+	assert.ErrorIs(t, err, ErrRowNotFound)
+	_, err = loader.Load(ctx, "../row1")
+	assert.ErrorIs(t, err, ErrRowNotFound)
+}
+
+func Test_LoadRow(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	loader := &flakyLoader{failures: 2}
+	row, err := loadRow(ctx, loader, policy, "row1")
+	assert.Nil(t, err)
+	assert.Equal(t, "data", row.Data)
+	assert.Equal(t, 3, loader.calls)
+
+	loader = &flakyLoader{failures: 3}
+	_, err = loadRow(ctx, loader, policy, "row1")
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, loader.calls)
+
+	// 数据行不存在时不重试
+	calls := 0
+	notFound := FileLoader{Dir: t.TempDir()}
+	_, err = loadRow(ctx, countingLoader{notFound, &calls}, policy, "row1")
+	assert.ErrorIs(t, err, ErrRowNotFound)
+	assert.Equal(t, 1, calls)
+}
+
+type countingLoader struct {
+	RowLoader
+	calls *int
+}
+
+func (l countingLoader) Load(ctx context.Context, rowId string) (Inventory, error) {
+	*l.calls++
+	return l.RowLoader.Load(ctx, rowId)
+}
+
+func Test_CacheRowStale(t *testing.T) {
+	ctx := context.Background()
+	rowId := ksuid.New().String()
+	policy := RetryPolicy{Attempts: 2, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	_, err := redis.Set(ctx, "inv:"+rowId, "old", 0)
+	assert.Nil(t, err)
+
+	// 读取失败时保留旧数据并记为过期
+	scheduleFirst(t, rowId, 1)
+	_, err = cacheRow(ctx, &flakyLoader{failures: 2}, policy)
+	assert.NotNil(t, err)
+	cached, err := redis.Get(ctx, "inv:"+rowId)
+	assert.Nil(t, err)
+	assert.Equal(t, "old", cached)
+	_, err = redis.ZScore(ctx, "stale:", rowId)
+	assert.Nil(t, err)
+
+	// 再次读取成功后移出过期记录
+	scheduleFirst(t, rowId, 1)
+	_, err = cacheRow(ctx, &flakyLoader{}, policy)
+	assert.Nil(t, err)
+	_, err = redis.ZScore(ctx, "stale:", rowId)
+	assert.NotNil(t, err)
+
+	scheduleFirst(t, rowId, 0)
+	_, err = cacheRow(ctx, &flakyLoader{}, policy)
+	assert.Nil(t, err)
+}
+
+// scheduleFirst 调度数据行并排在所有已到期的数据行之前，保证下一次cacheRow处理的是它
+func scheduleFirst(t *testing.T, rowId string, delay int64) {
+	ctx := context.Background()
+	ScheduleRowCache(ctx, rowId, delay)
+	_, err := redis.ZAdd(ctx, "schedule:", 0, rowId)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,357 @@
+package retailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrProductNotFound 商品不存在
+	ErrProductNotFound = errors.New("product not found")
+	// ErrNotReserved 释放的库存超过了已预留的库存
+	ErrNotReserved = errors.New("stock not reserved")
+	// ErrInvalidCount 预留、释放的库存数量不是正数，或者库存的修改量为0
+	ErrInvalidCount = errors.New("invalid stock count")
+)
+
+// productRetries 商品在事务提交前被修改（例如并发的预留和结算）时的最大重试次数
+const productRetries = 5
+
+// LOWSTOCK 可用库存不大于该值时发出低库存报警
+var LOWSTOCK int64 = 10
+
+// LowStockChannel 低库存报警发布的频道
+const LowStockChannel = "low-stock-alerts:"
+
+// Product 商品目录中的一个商品，价格以分为单位，Reserved是已预留但还没有扣减的库存。
+// Stock只在创建商品时由SetProduct写入，之后只能通过AdjustStock、ReserveStock、ReleaseStock和结算修改。
+// 商品存储在散列product:<id>中，所有商品记录在集合products:中，
+// 每个分类的商品记录在按价格排序的有序集合category:<name>中，
+// 低库存的商品记录在按可用库存排序的有序集合low-stock:中。
+type Product struct {
+	Id         string   `json:"id"`
+	Name       string   `json:"name"`
+	Price      int64    `json:"price"`
+	Stock      int64    `json:"stock"`
+	Reserved   int64    `json:"reserved"`
+	Categories []string `json:"categories"`
+}
+
+// SetProduct 创建商品或更新商品的名称、价格和分类，并维护商品的分类索引。
+// 已存在的商品不会修改库存和预留库存，避免覆盖并发的预留和结算；product.Reserved总是被忽略。
+func SetProduct(ctx context.Context, product *Product) error {
+	key := "product:" + product.Id
+
+	err := watchProduct(ctx, func(tx *redis.Tx) error {
+		old, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, "name", product.Name, "price", product.Price, "categories", strings.Join(product.Categories, ","))
+			pipe.HSetNX(ctx, key, "stock", product.Stock)
+			pipe.HSetNX(ctx, key, "reserved", 0)
+			pipe.SAdd(ctx, "products:", product.Id)
+
+			// 从不再属于的分类中移除商品，再按最新价格加入所属的分类
+			for _, category := range splitCategories(old["categories"]) {
+				if !slices.Contains(product.Categories, category) {
+					pipe.ZRem(ctx, "category:"+category, product.Id)
+				}
+			}
+			for _, category := range product.Categories {
+				pipe.ZAdd(ctx, "category:"+category, redis.Z{Score: float64(product.Price), Member: product.Id})
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		logs.Warnw("failed to set product", "product", product.Id, "error", err)
+		return err
+	}
+
+	return refreshLowStock(ctx, product.Id)
+}
+
+// watchProduct 执行监视商品的事务，被监视的键在提交前被修改时重新执行fn
+func watchProduct(ctx context.Context, fn func(*redis.Tx) error, keys ...string) error {
+	var err error
+	for i := 0; i < productRetries; i++ {
+		if err = redis.Watch(ctx, fn, keys...); !errors.Is(err, goredis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
+
+// GetProduct 返回商品信息
+func GetProduct(ctx context.Context, id string) (*Product, error) {
+	data, err := redis.HGetAll(ctx, "product:"+id)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrProductNotFound, id)
+	}
+
+	return decodeProduct(id, data), nil
+}
+
+// GetProducts 使用流水线一次性获取多个商品，跳过已经不存在的商品
+func GetProducts(ctx context.Context, ids []string) ([]*Product, error) {
+	if len(ids) == 0 {
+		return []*Product{}, nil
+	}
+
+	pipe := redis.Pipeline()
+	cmds := make([]*goredis.MapStringStringCmd, 0, len(ids))
+	for _, id := range ids {
+		cmds = append(cmds, pipe.HGetAll(ctx, "product:"+id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	products := make([]*Product, 0, len(ids))
+	for i, id := range ids {
+		data, _ := cmds[i].Result()
+		if len(data) == 0 {
+			continue
+		}
+		products = append(products, decodeProduct(id, data))
+	}
+	return products, nil
+}
+
+// GetCategoryProducts 按价格从低到高返回分类下的商品
+func GetCategoryProducts(ctx context.Context, category string, offset, count int64) ([]*Product, error) {
+	ids, err := redis.ZRange(ctx, "category:"+category, offset, offset+count-1)
+	if err != nil {
+		return nil, err
+	}
+	return GetProducts(ctx, ids)
+}
+
+// DeleteProduct 删除商品以及它的分类索引和低库存记录
+func DeleteProduct(ctx context.Context, id string) error {
+	key := "product:" + id
+
+	err := watchProduct(ctx, func(tx *redis.Tx) error {
+		data, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return fmt.Errorf("%w: %s", ErrProductNotFound, id)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.SRem(ctx, "products:", id)
+			pipe.ZRem(ctx, "low-stock:", id)
+			for _, category := range splitCategories(data["categories"]) {
+				pipe.ZRem(ctx, "category:"+category, id)
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		logs.Warnw("failed to delete product", "product", id, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// stockScript 在可用库存和预留库存之间移动库存
+// KEYS: product:<id>
+// ARGV: count, reserve(1为预留，0为释放)
+// 返回值: -1 商品不存在，0 库存不足，1 成功
+const stockScript = `
+local data = redis.call("hmget", KEYS[1], "stock", "reserved")
+if not data[1] then
+    return -1
+end
+
+local stock, reserved, count = tonumber(data[1]), tonumber(data[2] or 0), tonumber(ARGV[1])
+if ARGV[2] == "1" then
+    if stock < count then
+        return 0
+    end
+    stock, reserved = stock - count, reserved + count
+else
+    if reserved < count then
+        return 0
+    end
+    stock, reserved = stock + count, reserved - count
+end
+
+redis.call("hset", KEYS[1], "stock", stock, "reserved", reserved)
+return 1
+`
+
+// adjustStockScript 增加或减少可用库存，可用库存不能小于0
+// KEYS: product:<id>
+// ARGV: delta
+// 返回值: {-1} 商品不存在，{0} 库存不足，{1, 修改之后的可用库存} 成功
+const adjustStockScript = `
+local stock = redis.call("hget", KEYS[1], "stock")
+if not stock then
+    return {-1}
+end
+
+stock = tonumber(stock) + tonumber(ARGV[1])
+if stock < 0 then
+    return {0}
+end
+redis.call("hset", KEYS[1], "stock", stock)
+return {1, stock}
+`
+
+// AdjustStock 入库或盘点时增加或减少商品的可用库存，返回修改之后的可用库存，预留库存不受影响
+func AdjustStock(ctx context.Context, id string, delta int64) (int64, error) {
+	if delta == 0 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidCount, delta)
+	}
+
+	res, err := evalScript(ctx, adjustStockScript, []string{"product:" + id}, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	status := res.([]interface{})
+	switch status[0].(int64) {
+	case -1:
+		return 0, fmt.Errorf("%w: %s", ErrProductNotFound, id)
+	case 0:
+		return 0, fmt.Errorf("%w: %s", ErrOutOfStock, id)
+	}
+
+	return status[1].(int64), refreshLowStock(ctx, id)
+}
+
+// ReserveStock 为未完成的订单预留库存，可用库存不足时返回ErrOutOfStock
+func ReserveStock(ctx context.Context, id string, count int64) error {
+	return moveStock(ctx, id, count, true)
+}
+
+// ReleaseStock 释放之前预留的库存，例如订单被取消或支付超时
+func ReleaseStock(ctx context.Context, id string, count int64) error {
+	return moveStock(ctx, id, count, false)
+}
+
+func moveStock(ctx context.Context, id string, count int64, reserve bool) error {
+	// 数量为负数时预留和释放会互换，预留库存可能变成负数
+	if count <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidCount, count)
+	}
+
+	mode := 0
+	if reserve {
+		mode = 1
+	}
+
+	res, err := evalScript(ctx, stockScript, []string{"product:" + id}, count, mode)
+	if err != nil {
+		return err
+	}
+
+	switch res.(int64) {
+	case -1:
+		return fmt.Errorf("%w: %s", ErrProductNotFound, id)
+	case 0:
+		if reserve {
+			return fmt.Errorf("%w: %s", ErrOutOfStock, id)
+		}
+		return fmt.Errorf("%w: %s", ErrNotReserved, id)
+	}
+
+	return refreshLowStock(ctx, id)
+}
+
+// LowStockAlert 商品的可用库存刚刚降到LOWSTOCK以下时发布的报警
+type LowStockAlert struct {
+	Product string `json:"product"`
+	Stock   int64  `json:"stock"`
+	Time    int64  `json:"time"`
+}
+
+// lowStockScript 根据可用库存维护低库存索引
+// KEYS: product:<id>, low-stock:
+// ARGV: id, LOWSTOCK
+// 返回值: 商品刚刚进入低库存索引时返回可用库存，否则返回-1
+const lowStockScript = `
+local stock = redis.call("hget", KEYS[1], "stock")
+if not stock or tonumber(stock) > tonumber(ARGV[2]) then
+    redis.call("zrem", KEYS[2], ARGV[1])
+    return -1
+end
+
+if redis.call("zadd", KEYS[2], stock, ARGV[1]) == 1 then
+    return tonumber(stock)
+end
+return -1
+`
+
+// refreshLowStock 更新商品的低库存记录，每次降到阈值以下只报警一次
+func refreshLowStock(ctx context.Context, ids ...string) error {
+	for _, id := range ids {
+		res, err := evalScript(ctx, lowStockScript, []string{"product:" + id, "low-stock:"}, id, LOWSTOCK)
+		if err != nil {
+			return err
+		}
+
+		stock := res.(int64)
+		if stock < 0 {
+			continue
+		}
+
+		alert := LowStockAlert{Product: id, Stock: stock, Time: time.Now().Unix()}
+		payload, err := json.Marshal(alert)
+		if err != nil {
+			logs.Warnw("failed to marshal low stock alert", "alert", alert, "error", err)
+			continue
+		}
+		if _, err := redis.Publish(ctx, LowStockChannel, payload); err != nil {
+			logs.Warnw("failed to publish low stock alert", "alert", alert, "error", err)
+		}
+	}
+	return nil
+}
+
+// GetLowStock 按可用库存从少到多返回低库存的商品
+func GetLowStock(ctx context.Context, offset, count int64) ([]*Product, error) {
+	ids, err := redis.ZRange(ctx, "low-stock:", offset, offset+count-1)
+	if err != nil {
+		return nil, err
+	}
+	return GetProducts(ctx, ids)
+}
+
+func decodeProduct(id string, data map[string]string) *Product {
+	price, _ := strconv.ParseInt(data["price"], 10, 64)
+	stock, _ := strconv.ParseInt(data["stock"], 10, 64)
+	reserved, _ := strconv.ParseInt(data["reserved"], 10, 64)
+	return &Product{Id: id, Name: data["name"], Price: price, Stock: stock, Reserved: reserved,
+		Categories: splitCategories(data["categories"])}
+}
+
+func splitCategories(categories string) []string {
+	if categories == "" {
+		return []string{}
+	}
+	return strings.Split(categories, ",")
+}
@@ -0,0 +1,74 @@
+package retailer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/chaos-io/chaos/redis"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Product(t *testing.T) {
+	ctx := context.Background()
+	id := ksuid.New().String()
+	category := ksuid.New().String()
+
+	product := &Product{Id: id, Name: "apple", Price: 300, Stock: 12, Categories: []string{category, "fruit"}}
+	assert.Nil(t, SetProduct(ctx, product))
+
+	products, err := GetCategoryProducts(ctx, category, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, products, 1)
+	assert.Equal(t, product, products[0])
+
+	assert.Nil(t, ReserveStock(ctx, id, 5))
+	assert.ErrorIs(t, ReserveStock(ctx, id, 8), ErrOutOfStock)
+	assert.ErrorIs(t, ReleaseStock(ctx, id, 6), ErrNotReserved)
+	assert.ErrorIs(t, ReserveStock(ctx, id, -1), ErrInvalidCount)
+	assert.ErrorIs(t, ReleaseStock(ctx, id, 0), ErrInvalidCount)
+
+	score, err := redis.ZScore(ctx, "low-stock:", id)
+	assert.Nil(t, err)
+	assert.Equal(t, float64(7), score)
+
+	// 修改价格不会覆盖库存和预留库存
+	assert.Nil(t, SetProduct(ctx, &Product{Id: id, Name: "apple", Price: 350, Stock: 100, Categories: product.Categories}))
+	got, err := GetProduct(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(350), got.Price)
+	assert.Equal(t, int64(7), got.Stock)
+	assert.Equal(t, int64(5), got.Reserved)
+
+	assert.Nil(t, ReleaseStock(ctx, id, 5))
+	stock, err := AdjustStock(ctx, id, -2)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), stock)
+	_, err = AdjustStock(ctx, id, -11)
+	assert.ErrorIs(t, err, ErrOutOfStock)
+	_, err = AdjustStock(ctx, id, 0)
+	assert.ErrorIs(t, err, ErrInvalidCount)
+
+	got, err = GetProduct(ctx, id)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), got.Stock)
+	assert.Equal(t, int64(0), got.Reserved)
+
+	row, err := Get(ctx, id)
+	assert.Nil(t, err)
+	var cached Product
+	assert.Nil(t, json.Unmarshal([]byte(row.Data), &cached))
+	assert.Equal(t, got, &cached)
+
+	// 移出分类之后分类索引中不再有该商品
+	got.Categories = []string{"fruit"}
+	assert.Nil(t, SetProduct(ctx, got))
+	products, err = GetCategoryProducts(ctx, category, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, products, 0)
+
+	assert.Nil(t, DeleteProduct(ctx, id))
+	_, err = GetProduct(ctx, id)
+	assert.ErrorIs(t, err, ErrProductNotFound)
+}
@@ -0,0 +1,167 @@
+package retailer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// SESSIONTTL 会话的滑动过期时间，每次访问会话都会重新计时
+var SESSIONTTL = 7 * 24 * time.Hour
+
+// ErrSessionNotFound 会话不存在或已过期
+var ErrSessionNotFound = errors.New("session not found")
+
+// UpdateSession 创建或刷新会话，并记录用户浏览过的商品。每个会话是一个独立的散列session:<token>，
+// 依靠过期时间自动清理，用户的所有会话记录在有序集合user-sessions:<user>中
+func UpdateSession(ctx context.Context, token, user, item string) error {
+	now := time.Now().Unix()
+	session := "session:" + token
+
+	pipe := redis.Pipeline()
+	pipe.HSet(ctx, session, "user", user, "last", now)
+	pipe.HSetNX(ctx, session, "created", now)
+	pipe.ZAdd(ctx, "user-sessions:"+user, redis.Z{Score: float64(now), Member: token})
+	pipe.Expire(ctx, "user-sessions:"+user, SESSIONTTL)
+
+	// 记录用户浏览过的商品，只保留最近浏览过的25个商品
+	if len(item) > 0 {
+		pipe.ZAdd(ctx, "viewed:"+token, redis.Z{Score: float64(now), Member: item})
+		pipe.ZRemRangeByRank(ctx, "viewed:"+token, 0, -26)
+		pipe.ZIncrBy(ctx, "viewed:", -1, item)
+	}
+
+	touchSession(ctx, pipe, token)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// CheckSession 返回会话对应的用户，并延长会话的过期时间
+func CheckSession(ctx context.Context, token string) (string, error) {
+	user, err := sessionUser(ctx, token)
+	if err != nil {
+		return "", err
+	}
+
+	pipe := redis.Pipeline()
+	pipe.HSet(ctx, "session:"+token, "last", time.Now().Unix())
+	pipe.ZAdd(ctx, "user-sessions:"+user, redis.Z{Score: float64(time.Now().Unix()), Member: token})
+	pipe.Expire(ctx, "user-sessions:"+user, SESSIONTTL)
+	touchSession(ctx, pipe, token)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+
+	return user, nil
+}
+
+// sessionUser 返回会话对应的用户，只有会话不存在时才返回ErrSessionNotFound
+func sessionUser(ctx context.Context, token string) (string, error) {
+	user, err := redis.HGet(ctx, "session:"+token, "user")
+	if errors.Is(err, goredis.Nil) || (err == nil && user == "") {
+		return "", ErrSessionNotFound
+	}
+	return user, err
+}
+
+// touchSession 延长会话及其浏览记录的过期时间，购物车按CARTTTL在每次修改时单独计时
+func touchSession(ctx context.Context, pipe redis.Pipeliner, token string) {
+	pipe.Expire(ctx, "session:"+token, SESSIONTTL)
+	pipe.Expire(ctx, "viewed:"+token, SESSIONTTL)
+}
+
+// sessionKeys 返回注销会话时需要删除的所有键，包括浏览记录和购物车
+func sessionKeys(token string) []string {
+	return []string{"session:" + token, "viewed:" + token, "cart:" + token, "cart-prices:" + token}
+}
+
+// Session 用户的一个会话
+type Session struct {
+	Token   string `json:"token"`
+	Created int64  `json:"created"`
+	Last    int64  `json:"last"`
+}
+
+// ListSessions 按最近访问时间从新到旧列出用户仍然有效的会话，并清理已过期的记录
+func ListSessions(ctx context.Context, user string) ([]*Session, error) {
+	key := "user-sessions:" + user
+	cutoff := time.Now().Add(-SESSIONTTL).Unix()
+	pipe := redis.Pipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(cutoff, 10))
+	tokensCmd := pipe.ZRevRange(ctx, key, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	tokens := tokensCmd.Val()
+	pipe = redis.Pipeline()
+	cmds := make([]*goredis.MapStringStringCmd, 0, len(tokens))
+	for _, token := range tokens {
+		cmds = append(cmds, pipe.HGetAll(ctx, "session:"+token))
+	}
+	if len(tokens) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	sessions := make([]*Session, 0, len(tokens))
+	expired := make([]interface{}, 0)
+	for i, token := range tokens {
+		data, _ := cmds[i].Result()
+		// 会话已经过期，但还没有被从索引中清理
+		if data["user"] != user {
+			expired = append(expired, token)
+			continue
+		}
+
+		created, _ := strconv.ParseInt(data["created"], 10, 64)
+		last, _ := strconv.ParseInt(data["last"], 10, 64)
+		sessions = append(sessions, &Session{Token: token, Created: created, Last: last})
+	}
+
+	if len(expired) > 0 {
+		if _, err := redis.ZRem(ctx, key, expired...); err != nil {
+			return sessions, err
+		}
+	}
+
+	return sessions, nil
+}
+
+// Logout 注销一个会话，同时删除会话的浏览记录和购物车
+func Logout(ctx context.Context, token string) error {
+	user, err := sessionUser(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	pipe := redis.Pipeline()
+	pipe.Del(ctx, sessionKeys(token)...)
+	pipe.ZRem(ctx, "user-sessions:"+user, token)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// LogoutAll 注销用户在所有设备上的会话，返回注销的会话数量
+func LogoutAll(ctx context.Context, user string) (int, error) {
+	key := "user-sessions:" + user
+	tokens, err := redis.ZRange(ctx, key, 0, -1)
+	if err != nil {
+		return 0, err
+	}
+
+	keys := []string{key}
+	for _, token := range tokens {
+		keys = append(keys, sessionKeys(token)...)
+	}
+	if err := redis.Del(ctx, keys...); err != nil {
+		return 0, err
+	}
+
+	return len(tokens), nil
+}
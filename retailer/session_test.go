@@ -0,0 +1,56 @@
+package retailer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chaos-io/chaos/redis"
+	"github.com/segmentio/ksuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Session(t *testing.T) {
+	ctx := context.Background()
+	username := ksuid.New().String()
+	phone, laptop := ksuid.New().String(), ksuid.New().String()
+
+	assert.Nil(t, UpdateSession(ctx, phone, username, "item1"))
+	assert.Nil(t, UpdateSession(ctx, laptop, username, ""))
+	assert.Nil(t, AddToCart(ctx, phone, "item1", 1))
+
+	user, err := CheckSession(ctx, phone)
+	assert.Nil(t, err)
+	assert.Equal(t, username, user)
+
+	ttl, err := redis.TTL(ctx, "cart:"+phone)
+	assert.Nil(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+
+	sessions, err := ListSessions(ctx, username)
+	assert.Nil(t, err)
+	assert.Len(t, sessions, 2)
+
+	assert.Nil(t, Logout(ctx, phone))
+	_, err = CheckSession(ctx, phone)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	count, err := LogoutAll(ctx, username)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	_, err = CheckSession(ctx, laptop)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func Test_SessionBackendError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 后端出错时不能当作会话不存在
+	_, err := CheckSession(ctx, ksuid.New().String())
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, ErrSessionNotFound)
+	err = Logout(ctx, ksuid.New().String())
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, ErrSessionNotFound)
+}
@@ -0,0 +1,117 @@
+package retailer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chaos-io/chaos/logs"
+)
+
+// Job 后台任务。Run执行一轮任务，返回本轮是否处理了数据；
+// 没有数据需要处理或出错时，Worker会休眠Interval后再执行下一轮。
+type Job struct {
+	Name     string
+	Run      func(ctx context.Context) (bool, error)
+	Interval time.Duration
+}
+
+// WorkerStats 后台任务的运行状态
+type WorkerStats struct {
+	Name      string    `json:"name"`
+	Running   int       `json:"running"`
+	Runs      int64     `json:"runs"`
+	Errors    int64     `json:"errors"`
+	LastRun   time.Time `json:"lastRun"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// Worker 并发运行同一个Job的多个实例，直到ctx被取消或调用Stop
+type Worker struct {
+	job    Job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	stats WorkerStats
+}
+
+// StartWorker 启动concurrency个实例运行job
+func StartWorker(ctx context.Context, job Job, concurrency int) *Worker {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Worker{job: job, cancel: cancel, stats: WorkerStats{Name: job.Name}}
+
+	for i := 0; i < max(concurrency, 1); i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.run(ctx)
+		}()
+	}
+	return w
+}
+
+// RunJob 在当前goroutine中运行job直到ctx被取消
+func RunJob(ctx context.Context, job Job) {
+	w := &Worker{job: job, stats: WorkerStats{Name: job.Name}}
+	w.run(ctx)
+}
+
+func (w *Worker) run(ctx context.Context) {
+	w.update(func(stats *WorkerStats) { stats.Running++ })
+	defer w.update(func(stats *WorkerStats) { stats.Running-- })
+
+	for ctx.Err() == nil {
+		busy, err := w.job.Run(ctx)
+		w.update(func(stats *WorkerStats) {
+			stats.Runs++
+			stats.LastRun = time.Now()
+			if err != nil {
+				stats.Errors++
+				stats.LastError = err.Error()
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			logs.Warnw("worker job failed", "job", w.job.Name, "error", err)
+		}
+
+		if busy && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(w.job.Interval):
+		}
+	}
+}
+
+func (w *Worker) update(fn func(stats *WorkerStats)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(&w.stats)
+}
+
+// Stats 返回任务的运行状态
+func (w *Worker) Stats() WorkerStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+// Healthy 任务仍在运行，并且在maxAge内至少执行过一轮
+func (w *Worker) Healthy(maxAge time.Duration) bool {
+	stats := w.Stats()
+	return stats.Running > 0 && time.Since(stats.LastRun) <= maxAge
+}
+
+// Stop 通知所有实例退出，并等待正在执行的一轮任务结束
+func (w *Worker) Stop() {
+	w.cancel()
+	w.Wait()
+}
+
+// Wait 等待所有实例退出
+func (w *Worker) Wait() {
+	w.wg.Wait()
+}
@@ -0,0 +1,39 @@
+package retailer
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Worker(t *testing.T) {
+	var runs int32
+	job := Job{
+		Name: "test",
+		Run: func(ctx context.Context) (bool, error) {
+			if atomic.AddInt32(&runs, 1)%2 == 0 {
+				return false, errors.New("failed")
+			}
+			return true, nil
+		},
+		Interval: 10 * time.Millisecond,
+	}
+
+	worker := StartWorker(context.Background(), job, 3)
+	time.Sleep(100 * time.Millisecond)
+
+	stats := worker.Stats()
+	assert.Equal(t, 3, stats.Running)
+	assert.True(t, worker.Healthy(time.Second))
+
+	worker.Stop()
+	stats = worker.Stats()
+	assert.Equal(t, 0, stats.Running)
+	assert.Greater(t, stats.Errors, int64(0))
+	assert.Equal(t, "failed", stats.LastError)
+	assert.False(t, worker.Healthy(time.Second))
+}